@@ -0,0 +1,209 @@
+/*
+Package ansiblevault reads and writes Ansible Vault files, so that secrets
+already kept with ansible-vault can be used and converted with the packages of
+this repository.
+
+The format (version 1.1 and 1.2, cipher AES256) is described at
+https://docs.ansible.com/ansible/latest/vault_guide/vault_using_encrypted_content.html.
+Keys are derived from the vault password and a random 32 bytes salt with
+PBKDF2-HMAC-SHA256 (10000 iterations) into an AES-256 key, an HMAC-SHA256 key
+and a counter. The plaintext is padded with PKCS#7, encrypted with AES-256 in
+counter mode and authenticated with HMAC-SHA256. The salt, HMAC and
+ciphertext are hex encoded, joined by newlines, hex encoded again and wrapped
+at 80 columns below the header:
+
+	$ANSIBLE_VAULT;1.1;AES256
+	6238...
+
+Version 1.2 adds the vault ID, a label that tells which password to use, to
+the header. As in Ansible, the ID is not authenticated and a wrong password
+can not be told apart from modified data.
+*/
+package ansiblevault
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Header is the start of every vault file.
+const Header = "$ANSIBLE_VAULT"
+
+// Cipher is the only cipher Ansible writes.
+const Cipher = "AES256"
+
+// DefaultID is the vault ID of files written without one.
+const DefaultID = "default"
+
+const (
+	iterations = 10000
+	saltSize   = 32
+	keySize    = 32
+	width      = 80
+)
+
+var (
+	// ErrInvalid is returned for data that is not a vault file.
+	ErrInvalid = errors.New("invalid ansible vault file")
+	// ErrDecrypt is returned when the HMAC does not match: the password is
+	// wrong or the file was modified.
+	ErrDecrypt = errors.New("could not decrypt ansible vault file, wrong password or modified data")
+)
+
+// A Vault encrypts and decrypts vault files with a password. If ID is set to
+// anything but DefaultID, Encrypt writes version 1.2 files labeled with it.
+type Vault struct {
+	password []byte
+	ID       string
+}
+
+// New creates a new Vault instance. Like ansible-vault, pass the contents of
+// a password file without the trailing newline.
+func New(password []byte) *Vault {
+	return &Vault{password: password}
+}
+
+// keys derives the AES key, HMAC key and initial counter from the password
+// and salt.
+func (v Vault) keys(salt []byte) (aesKey, hmacKey, iv []byte) {
+	k := pbkdf2.Key(v.password, salt, iterations, 2*keySize+aes.BlockSize, sha256.New)
+	return k[:keySize], k[keySize : 2*keySize], k[2*keySize:]
+}
+
+// Encrypt encrypts msg into a vault file.
+func (v Vault) Encrypt(msg []byte) ([]byte, error) {
+	if len(v.password) == 0 {
+		return nil, errors.New("empty ansible vault password")
+	}
+	if strings.ContainsAny(v.ID, ";\n\r") {
+		return nil, errors.New("invalid vault id " + v.ID)
+	}
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return v.encrypt(msg, salt)
+}
+
+func (v Vault) encrypt(msg, salt []byte) ([]byte, error) {
+	aesKey, hmacKey, iv := v.keys(salt)
+	p := aes.BlockSize - len(msg)%aes.BlockSize
+	ct := append(append([]byte{}, msg...), bytes.Repeat([]byte{byte(p)}, p)...)
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	cipher.NewCTR(block, iv).XORKeyStream(ct, ct)
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(ct)
+
+	body := hex.EncodeToString([]byte(hex.EncodeToString(salt) + "\n" +
+		hex.EncodeToString(mac.Sum(nil)) + "\n" + hex.EncodeToString(ct)))
+	var out bytes.Buffer
+	if v.ID == "" || v.ID == DefaultID {
+		out.WriteString(Header + ";1.1;" + Cipher + "\n")
+	} else {
+		out.WriteString(Header + ";1.2;" + Cipher + ";" + v.ID + "\n")
+	}
+	for len(body) > width {
+		out.WriteString(body[:width] + "\n")
+		body = body[width:]
+	}
+	out.WriteString(body + "\n")
+	return out.Bytes(), nil
+}
+
+// Decrypt decrypts a vault file. The vault ID of the file is not checked;
+// use ID to pick the password.
+func (v Vault) Decrypt(msg []byte) ([]byte, error) {
+	_, body, err := parse(msg)
+	if err != nil {
+		return nil, err
+	}
+	fields := bytes.Split(body, []byte("\n"))
+	if len(fields) != 3 {
+		return nil, ErrInvalid
+	}
+	var salt, sum, ct []byte
+	for i, p := range []*[]byte{&salt, &sum, &ct} {
+		if *p, err = hex.DecodeString(string(fields[i])); err != nil {
+			return nil, ErrInvalid
+		}
+	}
+	if len(ct) == 0 || len(ct)%aes.BlockSize != 0 {
+		return nil, ErrInvalid
+	}
+
+	aesKey, hmacKey, iv := v.keys(salt)
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(ct)
+	if subtle.ConstantTimeCompare(mac.Sum(nil), sum) != 1 {
+		return nil, ErrDecrypt
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(ct))
+	cipher.NewCTR(block, iv).XORKeyStream(out, ct)
+	p := int(out[len(out)-1])
+	if p == 0 || p > aes.BlockSize || !bytes.Equal(out[len(out)-p:], bytes.Repeat([]byte{byte(p)}, p)) {
+		return nil, ErrDecrypt
+	}
+	return out[:len(out)-p], nil
+}
+
+// IsEncrypted reports whether b starts with a vault header.
+func IsEncrypted(b []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(b, " \t\r\n"), []byte(Header+";"))
+}
+
+// ID returns the vault ID of a vault file: the label of a version 1.2 file,
+// or DefaultID.
+func ID(msg []byte) (string, error) {
+	id, _, err := parse(msg)
+	return id, err
+}
+
+// parse checks the header of a vault file and returns its vault ID and its
+// body, hex decoded. Like Ansible, it accepts indented lines, as in vault
+// values embedded in YAML.
+func parse(msg []byte) (id string, body []byte, err error) {
+	lines := strings.Split(strings.TrimSpace(string(msg)), "\n")
+	header := strings.Split(strings.TrimSpace(lines[0]), ";")
+	if len(header) < 3 || header[0] != Header {
+		return "", nil, ErrInvalid
+	}
+	switch {
+	case header[1] == "1.1" && len(header) == 3:
+		id = DefaultID
+	case header[1] == "1.2" && len(header) == 4:
+		id = strings.TrimSpace(header[3])
+	case header[1] == "1.2" && len(header) == 3:
+		id = DefaultID
+	default:
+		return "", nil, errors.New("unsupported ansible vault version " + header[1])
+	}
+	if strings.TrimSpace(header[2]) != Cipher {
+		return "", nil, errors.New("unsupported ansible vault cipher " + header[2])
+	}
+	var hexBody strings.Builder
+	for _, l := range lines[1:] {
+		hexBody.WriteString(strings.TrimSpace(l))
+	}
+	if body, err = hex.DecodeString(hexBody.String()); err != nil {
+		return "", nil, ErrInvalid
+	}
+	return id, body, nil
+}
@@ -0,0 +1,120 @@
+package ansiblevault
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// The files in testdata were written with Ansible's algorithm, salted with
+// the bytes 0 to 31 (secrets-1.1.yml) and 32 to 63 (secrets-1.2.yml).
+var vectors = []struct {
+	file, password, id, plaintext string
+	salt                          byte
+}{
+	{"secrets-1.1.yml", "qwerty", DefaultID, "db_password: hunter2\napi_token: 0123456789abcdef\n", 0},
+	{"secrets-1.2.yml", "hunter2", "prod", "db_password: qwerty\n", 32},
+}
+
+func TestVectors(t *testing.T) {
+	for _, tv := range vectors {
+		b, err := ioutil.ReadFile("testdata/" + tv.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := New([]byte(tv.password))
+		pt, err := v.Decrypt(b)
+		if err != nil || string(pt) != tv.plaintext {
+			t.Errorf("%s decrypts to %q, %v.", tv.file, pt, err)
+		}
+		if id, err := ID(b); err != nil || id != tv.id {
+			t.Errorf("%s has vault id %q, %v.", tv.file, id, err)
+		}
+
+		salt := make([]byte, saltSize)
+		for i := range salt {
+			salt[i] = tv.salt + byte(i)
+		}
+		v.ID = tv.id
+		if out, err := v.encrypt([]byte(tv.plaintext), salt); err != nil || !bytes.Equal(out, b) {
+			t.Errorf("Encrypting %s returned:\n%s%v", tv.file, out, err)
+		}
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	v := New([]byte("qwerty"))
+	for _, msg := range []string{"", "a", "exactly 16 bytes", strings.Repeat("x", 1000)} {
+		out, err := v.Encrypt([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsEncrypted(out) || !strings.HasPrefix(string(out), "$ANSIBLE_VAULT;1.1;AES256\n") {
+			t.Errorf("Unexpected vault file:\n%s", out)
+		}
+		for _, l := range strings.Split(string(out), "\n") {
+			if len(l) > width {
+				t.Errorf("Line of %d bytes.", len(l))
+			}
+		}
+		if pt, err := v.Decrypt(out); err != nil || string(pt) != msg {
+			t.Errorf("%q decrypts to %q, %v.", msg, pt, err)
+		}
+	}
+
+	v.ID = "prod"
+	out, _ := v.Encrypt([]byte("secret"))
+	if id, _ := ID(out); id != "prod" || !strings.HasPrefix(string(out), "$ANSIBLE_VAULT;1.2;AES256;prod\n") {
+		t.Errorf("Unexpected vault file with id:\n%s", out)
+	}
+	v.ID = "a;b"
+	if _, err := v.Encrypt([]byte("secret")); err == nil {
+		t.Errorf("Encrypt accepts a vault id with a semicolon.")
+	}
+	if _, err := New(nil).Encrypt([]byte("secret")); err == nil {
+		t.Errorf("Encrypt accepts an empty password.")
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	v := New([]byte("qwerty"))
+	out, _ := v.Encrypt([]byte("secret"))
+	if _, err := New([]byte("wrong")).Decrypt(out); err != ErrDecrypt {
+		t.Errorf("Expected ErrDecrypt with a wrong password, got %v.", err)
+	}
+
+	// Flip a bit of the ciphertext, at the end of the body.
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	last := []byte(lines[len(lines)-1])
+	last[len(last)-1] ^= 1
+	lines[len(lines)-1] = string(last)
+	if _, err := v.Decrypt([]byte(strings.Join(lines, "\n"))); err == nil {
+		t.Errorf("Modified vault file decrypts.")
+	}
+
+	for _, bad := range []string{"", "secret", "$ANSIBLE_VAULT;1.1;AES256\nzz", "$ANSIBLE_VAULT;1.1;AES256\n6162"} {
+		if _, err := v.Decrypt([]byte(bad)); err != ErrInvalid {
+			t.Errorf("Expected ErrInvalid for %q, got %v.", bad, err)
+		}
+	}
+	for _, bad := range []string{"$ANSIBLE_VAULT;1.0;AES\n00", "$ANSIBLE_VAULT;1.1;AES128\n00"} {
+		if _, err := v.Decrypt([]byte(bad)); err == nil {
+			t.Errorf("Decrypt accepts %q.", bad)
+		}
+	}
+}
+
+func TestEmbedded(t *testing.T) {
+	// Values encrypted with ansible-vault encrypt_string are indented in
+	// YAML documents.
+	v := New([]byte("qwerty"))
+	out, _ := v.Encrypt([]byte("hunter2"))
+	indented := "          " + strings.Replace(strings.TrimSpace(string(out)), "\n", "\n          ", -1) + "\n"
+	if !IsEncrypted([]byte(indented)) {
+		t.Errorf("IsEncrypted does not recognize an indented value.")
+	}
+	if pt, err := v.Decrypt([]byte(indented)); err != nil || string(pt) != "hunter2" {
+		t.Errorf("Indented value decrypts to %q, %v.", pt, err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/andmarios/crypto/nacl/assetsecret"
+)
+
+// runAssets encrypts an asset directory for embedding, usually from a
+// go:generate directive.
+func runAssets(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("assets", flag.ContinueOnError)
+	keys := addKeyFlags(fs)
+	output := fs.String("o", "", "write the encrypted assets and their manifest to `dir`")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("Usage: crypto assets [flags] -o dir source\n"))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *output == "" {
+		return errors.New("assets needs a source directory and -o")
+	}
+
+	c, err := keys.cipher()
+	if err != nil {
+		return err
+	}
+	_, err = assetsecret.Generate(*output, os.DirFS(fs.Arg(0)), c)
+	return err
+}
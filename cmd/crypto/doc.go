@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/andmarios/crypto/nacl/docsecret"
+)
+
+// runDoc encrypts or decrypts the values of a JSON or YAML document, read
+// from a file or stdin.
+func runDoc(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("doc", flag.ContinueOnError)
+	keys := addKeyFlags(fs)
+	decrypt := fs.Bool("d", false, "decrypt instead of encrypting")
+	format := fs.String("format", "", "document `format`, json or yaml (default from the file extension, else json)")
+	output := fs.String("o", "", "write to `file` instead of stdout")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("Usage: crypto doc [flags] [file]\n"))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return errors.New("doc takes at most one file")
+	}
+
+	c, err := keys.cipher()
+	if err != nil {
+		return err
+	}
+	var in []byte
+	if fs.NArg() == 1 {
+		in, err = ioutil.ReadFile(fs.Arg(0))
+		if *format == "" {
+			switch filepath.Ext(fs.Arg(0)) {
+			case ".yaml", ".yml":
+				*format = "yaml"
+			}
+		}
+	} else {
+		in, err = ioutil.ReadAll(stdin)
+	}
+	if err != nil {
+		return err
+	}
+
+	d := docsecret.New(c)
+	var out []byte
+	switch {
+	case (*format == "" || *format == "json") && *decrypt:
+		out, err = d.DecryptJSON(in)
+	case *format == "" || *format == "json":
+		out, err = d.EncryptJSON(in)
+	case *format == "yaml" && *decrypt:
+		out, err = d.DecryptYAML(in)
+	case *format == "yaml":
+		out, err = d.EncryptYAML(in)
+	default:
+		return errors.New("unknown format " + *format)
+	}
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		return ioutil.WriteFile(*output, out, 0600)
+	}
+	_, err = stdout.Write(out)
+	return err
+}
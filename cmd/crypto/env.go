@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/andmarios/crypto/nacl/envsecret"
+)
+
+// runEnv encrypts the plain values of a .env file, read from a file or stdin.
+func runEnv(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("env", flag.ContinueOnError)
+	keys := addKeyFlags(fs)
+	output := fs.String("o", "", "write to `file` instead of stdout")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("Usage: crypto env [flags] [file]\n"))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return errors.New("env takes at most one file")
+	}
+
+	c, err := keys.cipher()
+	if err != nil {
+		return err
+	}
+	var in []byte
+	if fs.NArg() == 1 {
+		in, err = ioutil.ReadFile(fs.Arg(0))
+	} else {
+		in, err = ioutil.ReadAll(stdin)
+	}
+	if err != nil {
+		return err
+	}
+	out, err := envsecret.New(c).EncryptFile(in)
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		return ioutil.WriteFile(*output, out, 0600)
+	}
+	_, err = stdout.Write(out)
+	return err
+}
+
+// stringList is a flag that may be repeated.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(s string) error { *l = append(*l, s); return nil }
+
+// runExec runs a command with the decrypted values of .env files added to its
+// environment. The values are never written to disk.
+func runExec(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("exec", flag.ContinueOnError)
+	keys := addKeyFlags(fs)
+	var files stringList
+	fs.Var(&files, "env", "read variables from .env `file`, may be repeated (default .env)")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("Usage: crypto exec [flags] [--] command [arguments]\n"))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("exec needs a command to run")
+	}
+	if len(files) == 0 {
+		files = stringList{".env"}
+	}
+
+	c, err := keys.cipher()
+	if err != nil {
+		return err
+	}
+	vars, err := envsecret.New(c).Read(files...)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(fs.Arg(0), fs.Args()[1:]...)
+	// Variables from the files override the ones of the current environment.
+	// The key is not passed on, unless the files set it.
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, keyEnv+"=") && !strings.HasPrefix(kv, padEnv+"=") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	for k, v := range vars {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, os.Stderr
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	// Forward signals to the child, which exits on its own terms.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		for s := range signals {
+			cmd.Process.Signal(s)
+		}
+	}()
+	return cmd.Wait()
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/andmarios/crypto/nacl/sivsecret"
+)
+
+// filterName is the name of the git filter and diff driver.
+const filterName = "crypto"
+
+// runGit encrypts files in git repositories with a clean/smudge filter, like
+// git-crypt. Files are encrypted deterministically with sivsecret, so files
+// that did not change keep their ciphertext.
+//
+// The filter and the pre-commit hook only exist in clones that ran init; a
+// fresh clone commits plaintext. Run crypto git check HEAD in CI, or in a
+// server-side hook, to catch those commits.
+func runGit(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("git needs a subcommand: init, clean, smudge, textconv or check")
+	}
+	switch args[0] {
+	case "init":
+		return gitInit(args[1:], stdout)
+	case "clean":
+		return gitClean(stdin, stdout)
+	case "smudge":
+		return gitSmudge(stdin, stdout)
+	case "textconv":
+		if len(args) != 2 {
+			return errors.New("git textconv takes a file")
+		}
+		return gitTextconv(args[1], stdout)
+	case "check":
+		if len(args) > 2 {
+			return errors.New("git check takes at most a revision")
+		}
+		return gitCheck(args[1:])
+	}
+	return errors.New("unknown git subcommand " + args[0])
+}
+
+// git runs git in the current directory and returns its output.
+func git(args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.New("git " + strings.Join(args, " ") + ": " + strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// gitKeyFile returns the path of the key of the current repository. It is
+// inside the git directory, so it is never committed.
+func gitKeyFile() (string, error) {
+	out, err := git("rev-parse", "--absolute-git-dir")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(strings.TrimSpace(string(out)), "crypto", "key"), nil
+}
+
+func parseGitKey(b []byte) (*[32]byte, error) {
+	k, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(k) != 32 {
+		return nil, errors.New("git key is not 32 bytes in base64")
+	}
+	key := new([32]byte)
+	copy(key[:], k)
+	return key, nil
+}
+
+func gitCipher() (*sivsecret.SIVSecret, error) {
+	file, err := gitKeyFile()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.New("no key for this repository, run crypto git init")
+	}
+	key, err := parseGitKey(b)
+	if err != nil {
+		return nil, err
+	}
+	return sivsecret.NewFromKey(key, false), nil
+}
+
+// gitInit creates or imports the key of the repository, configures the
+// filter and installs the pre-commit hook. Files checked out encrypted are
+// checked out again, decrypted.
+func gitInit(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("git init", flag.ContinueOnError)
+	keyFile := fs.String("key", "", "import the key from `file` instead of creating one")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("Usage: crypto git init [-key file]\n"))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	file, err := gitKeyFile()
+	if err != nil {
+		return err
+	}
+	var key []byte
+	switch {
+	case *keyFile != "":
+		if key, err = ioutil.ReadFile(*keyFile); err != nil {
+			return err
+		}
+		if _, err = parseGitKey(key); err != nil {
+			return err
+		}
+	case fileExists(file):
+	default:
+		k := make([]byte, 32)
+		if _, err = io.ReadFull(rand.Reader, k); err != nil {
+			return err
+		}
+		key = []byte(base64.StdEncoding.EncodeToString(k) + "\n")
+	}
+	if key != nil {
+		if err = os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(file, key, 0600); err != nil {
+			return err
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	self := "'" + strings.Replace(exe, "'", `'\''`, -1) + "'"
+	for _, kv := range [][2]string{
+		{"filter." + filterName + ".clean", self + " git clean"},
+		{"filter." + filterName + ".smudge", self + " git smudge"},
+		{"filter." + filterName + ".required", "true"},
+		{"diff." + filterName + ".textconv", self + " git textconv"},
+	} {
+		if _, err = git("config", kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+	// Without the hook the filter still works, so only warn.
+	if err = installHook(filepath.Dir(filepath.Dir(file)), self); err != nil {
+		fmt.Fprintln(os.Stderr, "crypto: warning:", err)
+	}
+	if err = checkoutEncrypted(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Key: %s (share it to give access)\n"+
+		"Add the files to encrypt to .gitattributes, for example:\n"+
+		"\tsecrets/** filter=%s diff=%s\n"+
+		"Clones that did not run crypto git init commit plaintext; run\n"+
+		"\tcrypto git check HEAD\n"+
+		"in CI to catch them.\n", file, filterName, filterName)
+	return nil
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// installHook installs a pre-commit hook that runs crypto git check.
+func installHook(gitDir, self string) error {
+	hook := filepath.Join(gitDir, "hooks", "pre-commit")
+	line := self + " git check"
+	if b, err := ioutil.ReadFile(hook); err == nil {
+		if strings.Contains(string(b), " git check") {
+			return nil
+		}
+		return errors.New("a pre-commit hook exists already; add this line to it:\n\t" + line)
+	}
+	if err := os.MkdirAll(filepath.Dir(hook), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(hook, []byte("#!/bin/sh\nexec "+line+"\n"), 0755)
+}
+
+// filtered returns the paths among paths that use the crypto filter.
+func filtered(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	out, err := git(append([]string{"check-attr", "-z", "filter", "--"}, paths...)...)
+	if err != nil {
+		return nil, err
+	}
+	// The output is path NUL attribute NUL value NUL, for every path.
+	fields := strings.Split(string(out), "\x00")
+	var names []string
+	for i := 0; i+2 < len(fields); i += 3 {
+		if fields[i+2] == filterName {
+			names = append(names, fields[i])
+		}
+	}
+	return names, nil
+}
+
+func splitNUL(b []byte) []string {
+	s := strings.TrimSuffix(string(b), "\x00")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\x00")
+}
+
+// checkoutEncrypted checks out again the files of the working tree that
+// were checked out before the filter was configured.
+func checkoutEncrypted() error {
+	out, err := git("ls-files", "-z")
+	if err != nil {
+		return err
+	}
+	names, err := filtered(splitNUL(out))
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for _, n := range names {
+		b, err := ioutil.ReadFile(n)
+		if err == nil && sivsecret.IsDeterministic(b) {
+			stale = append(stale, n)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	// git considers these files unchanged; remove them so that checkout
+	// writes them again.
+	for _, n := range stale {
+		if err = os.Remove(n); err != nil {
+			return err
+		}
+	}
+	_, err = git(append([]string{"checkout", "--"}, stale...)...)
+	return err
+}
+
+// gitClean encrypts a file for the repository. Files that are encrypted with
+// the key of the repository already are passed as they are.
+func gitClean(stdin io.Reader, stdout io.Writer) error {
+	c, err := gitCipher()
+	if err != nil {
+		return err
+	}
+	in, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return err
+	}
+	if _, err = c.Decrypt(in); err == nil {
+		_, err = stdout.Write(in)
+		return err
+	}
+	out, err := c.Encrypt(in)
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(out)
+	return err
+}
+
+// gitSmudge decrypts a file for the working tree. Files committed in
+// plaintext are passed as they are.
+func gitSmudge(stdin io.Reader, stdout io.Writer) error {
+	c, err := gitCipher()
+	if err != nil {
+		return err
+	}
+	in, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return err
+	}
+	if !sivsecret.IsDeterministic(in) {
+		fmt.Fprintln(os.Stderr, "crypto: warning: a file was committed in plaintext")
+		_, err = stdout.Write(in)
+		return err
+	}
+	out, err := c.Decrypt(in)
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(out)
+	return err
+}
+
+// gitTextconv prints a file decrypted, for git diff and log.
+func gitTextconv(name string, stdout io.Writer) error {
+	in, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if sivsecret.IsDeterministic(in) {
+		c, err := gitCipher()
+		if err != nil {
+			return err
+		}
+		if in, err = c.Decrypt(in); err != nil {
+			return err
+		}
+	}
+	_, err = stdout.Write(in)
+	return err
+}
+
+// gitCheck refuses staged files that should be encrypted but are not,
+// because the filter is not configured. With a revision, it checks the files
+// of its tree instead. It needs no key, so it can run in CI too.
+func gitCheck(args []string) error {
+	list := []string{"diff", "--cached", "--name-only", "-z", "--diff-filter=ACMR"}
+	rev := ""
+	if len(args) == 1 {
+		rev = args[0]
+		list = []string{"ls-tree", "-r", "-z", "--name-only", rev}
+	}
+	out, err := git(list...)
+	if err != nil {
+		return err
+	}
+	names, err := filtered(splitNUL(out))
+	if err != nil {
+		return err
+	}
+	var plain []string
+	for _, n := range names {
+		blob, err := git("cat-file", "blob", rev+":"+n)
+		if err != nil {
+			return err
+		}
+		if !sivsecret.IsDeterministic(blob) {
+			plain = append(plain, n)
+		}
+	}
+	if len(plain) > 0 && rev != "" {
+		return errors.New(rev + " holds plaintext of " + strings.Join(plain, ", ") +
+			"; it was committed without the " + filterName + " filter")
+	}
+	if len(plain) > 0 {
+		return errors.New("refusing to commit plaintext of " + strings.Join(plain, ", ") +
+			"; the " + filterName + " filter is not configured, run crypto git init")
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/andmarios/crypto/nacl/adsecret"
+	"github.com/andmarios/crypto/nacl/envsecret"
+	"github.com/andmarios/crypto/nacl/padsecret"
+	"github.com/andmarios/crypto/nacl/saltsecret"
+)
+
+// Environment variables used when the key flags are not set.
+const (
+	keyEnv = "CRYPTO_KEY"
+	padEnv = "CRYPTO_PAD"
+)
+
+// keyFlags holds the key flags every command shares.
+type keyFlags struct {
+	key      string
+	keyFile  string
+	mode     string
+	pad      string
+	compress bool
+}
+
+func addKeyFlags(fs *flag.FlagSet) *keyFlags {
+	k := addSecretFlags(fs)
+	fs.StringVar(&k.mode, "mode", "salt", "encrypt with padsecret (pad) or saltsecret (salt)")
+	fs.StringVar(&k.pad, "pad", os.Getenv(padEnv), "the `pad` for padsecret (default $"+padEnv+")")
+	fs.BoolVar(&k.compress, "compress", false, "compress data before encrypting")
+	return k
+}
+
+// addSecretFlags adds only -key and -keyfile, for commands that use the key
+// as a password.
+func addSecretFlags(fs *flag.FlagSet) *keyFlags {
+	k := &keyFlags{}
+	fs.StringVar(&k.key, "key", "", "the encryption `key` (default $"+keyEnv+")")
+	fs.StringVar(&k.keyFile, "keyfile", "", "read the encryption key from `file`")
+	return k
+}
+
+// secret returns the key from -key, -keyfile or the environment, in this
+// order. A trailing newline in the key file is ignored.
+func (k *keyFlags) secret() ([]byte, error) {
+	if k.key != "" {
+		return []byte(k.key), nil
+	}
+	if k.keyFile == "" && os.Getenv(keyEnv) == "" {
+		return nil, errors.New("no key given, set -key, -keyfile or $" + keyEnv)
+	}
+	return envsecret.LoadKey(k.keyFile, keyEnv)
+}
+
+// cipher returns the padsecret or saltsecret instance the flags describe.
+func (k *keyFlags) cipher() (adsecret.Cipher, error) {
+	key, err := k.secret()
+	if err != nil {
+		return nil, err
+	}
+	switch k.mode {
+	case "pad":
+		return padsecret.New(string(key), k.pad, k.compress)
+	case "salt":
+		return saltsecret.New(key, k.compress), nil
+	}
+	return nil, errors.New("unknown mode " + k.mode + ", use pad or salt")
+}
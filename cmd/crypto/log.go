@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/andmarios/crypto/nacl/slogsecret"
+)
+
+// runLog decrypts the attributes encrypted by slogsecret in a log stream,
+// read from files or stdin.
+func runLog(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("log", flag.ContinueOnError)
+	keys := addKeyFlags(fs)
+	fs.Usage = func() {
+		fs.Output().Write([]byte("Usage: crypto log [flags] [file ...]\n"))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	c, err := keys.cipher()
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return slogsecret.Decode(stdin, stdout, c)
+	}
+
+	var firstErr error
+	for _, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		err = slogsecret.Decode(f, stdout, c)
+		f.Close()
+		if err != nil && firstErr == nil {
+			firstErr = errors.New(name + ": " + err.Error())
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,97 @@
+/*
+Command crypto encrypts and decrypts data with the packages of this
+repository.
+
+Usage:
+
+	crypto <command> [flags] [arguments]
+
+The commands are:
+
+	assets encrypt asset directories for embedding (go:generate)
+	doc    encrypt or decrypt the values of JSON and YAML documents
+	env    encrypt the values of .env files
+	exec   run a command with the decrypted variables of .env files
+	git    encrypt files in git repositories (clean/smudge filter)
+	log    decrypt the attributes of log streams written by slogsecret
+	vault  convert Ansible Vault files to and from the key's format
+	zip    write files to a WinZip AES encrypted zip archive
+
+Every command accepts the key flags:
+
+	-key string      the encryption key
+	-keyfile path    a file holding the encryption key
+	-mode pad|salt   encrypt with padsecret or saltsecret (default salt)
+	-pad string      the pad for padsecret
+	-compress        compress data before encrypting
+
+If neither -key nor -keyfile is set, the key is read from the CRYPTO_KEY
+environment variable. The pad defaults to the CRYPTO_PAD environment
+variable. The vault command uses the key as the vault password too, unless
+-vault-password-file is set. The zip command uses the key as the password of
+the archive and only accepts -key and -keyfile. The git command keeps its own
+key in the git directory and takes no key flags.
+
+Run "crypto <command> -h" for the flags of a command.
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// A command runs with its arguments (without the command name).
+type command struct {
+	name  string
+	short string
+	run   func(args []string, stdin io.Reader, stdout io.Writer) error
+}
+
+var commands = []command{
+	{"assets", "encrypt asset directories for embedding (go:generate)", runAssets},
+	{"doc", "encrypt or decrypt the values of JSON and YAML documents", runDoc},
+	{"env", "encrypt the values of .env files", runEnv},
+	{"exec", "run a command with the decrypted variables of .env files", runExec},
+	{"git", "encrypt files in git repositories (clean/smudge filter)", runGit},
+	{"log", "decrypt the attributes of log streams written by slogsecret", runLog},
+	{"vault", "convert Ansible Vault files to and from the key's format", runVault},
+	{"zip", "write files to a WinZip AES encrypted zip archive", runZip},
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdin, os.Stdout)
+	var exit *exec.ExitError
+	switch {
+	case errors.As(err, &exit):
+		// The command run by exec failed; exit with its status.
+		os.Exit(exit.ExitCode())
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "crypto:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		usage()
+		return errors.New("no command given")
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:], stdin, stdout)
+		}
+	}
+	usage()
+	return errors.New("unknown command " + args[0])
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: crypto <command> [flags] [arguments]\n\nCommands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.short)
+	}
+}
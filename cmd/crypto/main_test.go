@@ -0,0 +1,338 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andmarios/crypto/ansiblevault"
+	"github.com/andmarios/crypto/nacl/assetsecret"
+	"github.com/andmarios/crypto/nacl/slogsecret"
+	"github.com/andmarios/crypto/zipaes"
+)
+
+// TestMain runs the command instead of the tests when CRYPTO_TEST_MAIN is
+// set, so that git can run the test binary as its filter.
+func TestMain(m *testing.M) {
+	if os.Getenv("CRYPTO_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+var padFlags = []string{"-key", "qwerty", "-mode", "pad", "-pad", "qwertyuiopasdfghjklzxcvbnm123456"}
+
+// crypto runs a command with stdin and returns its output.
+func crypto(t *testing.T, stdin string, args ...string) string {
+	var out bytes.Buffer
+	if err := run(args, strings.NewReader(stdin), &out); err != nil {
+		t.Fatalf("crypto %s: %v", strings.Join(args, " "), err)
+	}
+	return out.String()
+}
+
+func TestRun(t *testing.T) {
+	if err := run(nil, nil, nil); err == nil {
+		t.Errorf("run() accepts no command.")
+	}
+	if err := run([]string{"nope"}, nil, nil); err == nil {
+		t.Errorf("run() accepts unknown commands.")
+	}
+}
+
+func TestKeys(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(keyFile, []byte("qwerty\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	doc := `{"a": "b"}`
+	enc := crypto(t, doc, append([]string{"doc"}, padFlags...)...)
+
+	os.Setenv(keyEnv, "qwerty")
+	defer os.Unsetenv(keyEnv)
+	for _, args := range [][]string{
+		{"doc", "-d", "-keyfile", keyFile, "-mode", "pad", "-pad", padFlags[5]},
+		{"doc", "-d", "-mode", "pad", "-pad", padFlags[5]},
+	} {
+		if out := crypto(t, enc, args...); !strings.Contains(out, `"a": "b"`) {
+			t.Errorf("crypto %s returned:\n%s", strings.Join(args, " "), out)
+		}
+	}
+
+	os.Unsetenv(keyEnv)
+	if err := run([]string{"doc"}, strings.NewReader(doc), ioutil.Discard); err == nil {
+		t.Errorf("doc runs without a key.")
+	}
+	if err := run([]string{"doc", "-key", "k", "-mode", "rsa"}, strings.NewReader(doc), ioutil.Discard); err == nil {
+		t.Errorf("doc accepts unknown modes.")
+	}
+}
+
+func TestDoc(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(in, []byte("db:\n  password: hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	enc := filepath.Join(dir, "config.enc.yml")
+	crypto(t, "", append([]string{"doc", "-o", enc}, append(padFlags, in)...)...)
+
+	b, err := ioutil.ReadFile(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "hunter2") || !strings.Contains(string(b), "  password: ENC[") {
+		t.Errorf("Unexpected encrypted document:\n%s", b)
+	}
+	if out := crypto(t, "", append([]string{"doc", "-d"}, append(padFlags, enc)...)...); out != "db:\n  password: hunter2\n" {
+		t.Errorf("doc -d returned:\n%s", out)
+	}
+
+	out := crypto(t, `{"x": 1}`, append([]string{"doc", "-mode", "salt"}, padFlags[:2]...)...)
+	if out = crypto(t, out, "doc", "-d", "-key", "qwerty"); out != "{\n  \"x\": 1\n}\n" {
+		t.Errorf("doc in salt mode returned:\n%s", out)
+	}
+}
+
+func TestExec(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain.env")
+	enc := filepath.Join(dir, ".env")
+	if err := ioutil.WriteFile(plain, []byte("# secrets\nCRYPTO_TEST_SECRET=hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	crypto(t, "", append([]string{"env", "-o", enc}, append(padFlags, plain)...)...)
+	if b, _ := ioutil.ReadFile(enc); strings.Contains(string(b), "hunter2") || !strings.HasPrefix(string(b), "# secrets\n") {
+		t.Errorf("Unexpected encrypted .env file:\n%s", b)
+	}
+
+	out := crypto(t, "", append(append([]string{"exec", "-env", enc}, padFlags...), "sh", "-c", "echo $CRYPTO_TEST_SECRET")...)
+	if out != "hunter2\n" {
+		t.Errorf("exec returned '%s'.", out)
+	}
+	if os.Getenv("CRYPTO_TEST_SECRET") != "" {
+		t.Errorf("exec changed the environment of the current process.")
+	}
+
+	// The key is not passed on to the command.
+	t.Setenv(keyEnv, "qwerty")
+	t.Setenv(padEnv, padFlags[5])
+	out = crypto(t, "", "exec", "-env", enc, "-mode", "pad", "env")
+	if !strings.Contains(out, "CRYPTO_TEST_SECRET=hunter2\n") || strings.Contains(out, keyEnv) || strings.Contains(out, padEnv) {
+		t.Errorf("exec passed this environment:\n%s", out)
+	}
+
+	err := run(append(append([]string{"exec", "-env", enc}, padFlags...), "sh", "-c", "exit 3"), nil, ioutil.Discard)
+	if e, ok := err.(*exec.ExitError); !ok || e.ExitCode() != 3 {
+		t.Errorf("exec returned %v, expected exit status 3.", err)
+	}
+}
+
+func TestLog(t *testing.T) {
+	k := &keyFlags{key: "qwerty", mode: "pad", pad: padFlags[5]}
+	pc, err := k.cipher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	slog.New(slogsecret.NewHandler(slog.NewJSONHandler(&b, nil), pc, "password")).Info("login", "password", "hunter2")
+
+	if out := crypto(t, b.String(), append([]string{"log"}, padFlags...)...); !strings.Contains(out, `"password":"hunter2"`) {
+		t.Errorf("log returned:\n%s", out)
+	}
+}
+
+func TestZip(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "docs")
+	os.MkdirAll(filepath.Join(src, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("hunter2"), 0600)
+	ioutil.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("qwerty"), 0600)
+	out := filepath.Join(dir, "docs.zip")
+	crypto(t, "", "zip", "-key", "s3cret", "-o", out, src)
+
+	zr, err := zip.OpenReader(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	zipaes.RegisterDecompressor(&zr.Reader, "s3cret")
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("Reading %s: %v", f.Name, err)
+		}
+		files[f.Name] = string(b)
+	}
+	if files["docs/a.txt"] != "hunter2" || files["docs/sub/b.txt"] != "qwerty" || len(files) != 4 {
+		t.Errorf("zip archived %q.", files)
+	}
+
+	if err = run([]string{"zip", "-mode", "pad", "-key", "s3cret", src}, nil, ioutil.Discard); err == nil {
+		t.Errorf("zip accepts -mode, which it does not use.")
+	}
+}
+
+func TestAssets(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "assets")
+	os.MkdirAll(filepath.Join(src, "css"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "css", "site.css"), []byte("body {}"), 0644)
+	out := filepath.Join(dir, "assets.enc")
+	crypto(t, "", append([]string{"assets", "-o", out}, append(padFlags, src)...)...)
+
+	k := &keyFlags{key: "qwerty", mode: "pad", pad: padFlags[5]}
+	c, err := k.cipher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := assetsecret.Open(os.DirFS(out), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := fsys.ReadFile("css/site.css"); err != nil || string(b) != "body {}" {
+		t.Errorf("Asset decrypts to %q, %v.", b, err)
+	}
+}
+
+// gitRun runs git in the current directory and returns its output.
+func gitRun(t *testing.T, args ...string) string {
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+func TestGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	t.Setenv("CRYPTO_TEST_MAIN", "1")
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	origin := t.TempDir()
+	t.Chdir(origin)
+	gitRun(t, "init", "-q")
+	crypto(t, "", "git", "init")
+	ioutil.WriteFile(".gitattributes", []byte("secret.txt filter=crypto diff=crypto\n"), 0644)
+	ioutil.WriteFile("secret.txt", []byte("hunter2\n"), 0644)
+	ioutil.WriteFile("public.txt", []byte("hello\n"), 0644)
+	gitRun(t, "add", ".")
+	gitRun(t, "commit", "-q", "-m", "first")
+
+	blob := gitRun(t, "cat-file", "blob", "HEAD:secret.txt")
+	if !strings.HasPrefix(blob, "SIV") || strings.Contains(blob, "hunter2") {
+		t.Errorf("secret.txt is committed as %q.", blob)
+	}
+	if b := gitRun(t, "cat-file", "blob", "HEAD:public.txt"); b != "hello\n" {
+		t.Errorf("public.txt is committed as %q.", b)
+	}
+	// Unchanged files do not churn.
+	os.Chtimes("secret.txt", time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+	gitRun(t, "add", ".")
+	if st := gitRun(t, "status", "--porcelain"); st != "" {
+		t.Errorf("Unchanged secret.txt shows as changed:\n%s", st)
+	}
+	ioutil.WriteFile("secret.txt", []byte("hunter3\n"), 0644)
+	if d := gitRun(t, "diff"); !strings.Contains(d, "-hunter2") || !strings.Contains(d, "+hunter3") {
+		t.Errorf("diff does not show plaintext:\n%s", d)
+	}
+	gitRun(t, "checkout", "--", "secret.txt")
+
+	// A clone is encrypted until the key is imported.
+	clone := t.TempDir()
+	gitRun(t, "clone", "-q", origin, clone)
+	t.Chdir(clone)
+	if b, _ := ioutil.ReadFile("secret.txt"); !bytes.HasPrefix(b, []byte("SIV")) {
+		t.Errorf("secret.txt is checked out as %q without the key.", b)
+	}
+	crypto(t, "", "git", "init", "-key", filepath.Join(origin, ".git", "crypto", "key"))
+	if b, _ := ioutil.ReadFile("secret.txt"); string(b) != "hunter2\n" {
+		t.Errorf("secret.txt is checked out as %q with the key.", b)
+	}
+	if st := gitRun(t, "status", "--porcelain"); st != "" {
+		t.Errorf("Decrypted clone shows changes:\n%s", st)
+	}
+
+	// Without the filter, the hook refuses plaintext.
+	gitRun(t, "config", "--remove-section", "filter.crypto")
+	ioutil.WriteFile("secret.txt", []byte("hunter4\n"), 0644)
+	gitRun(t, "add", "secret.txt")
+	out, err := exec.Command("git", "commit", "-q", "-m", "leak").CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "refusing to commit plaintext") {
+		t.Errorf("Plaintext was committed: %v\n%s", err, out)
+	}
+
+	// Without the hook, check catches the commit.
+	crypto(t, "", "git", "check", "HEAD")
+	gitRun(t, "commit", "-q", "--no-verify", "-m", "leak")
+	if err = run([]string{"git", "check", "HEAD"}, nil, ioutil.Discard); err == nil || !strings.Contains(err.Error(), "HEAD holds plaintext of secret.txt") {
+		t.Errorf("check accepts a plaintext commit: %v", err)
+	}
+
+	// An existing pre-commit hook is kept, with a warning.
+	other := t.TempDir()
+	t.Chdir(other)
+	gitRun(t, "init", "-q")
+	os.MkdirAll(filepath.Join(".git", "hooks"), 0755)
+	ioutil.WriteFile(filepath.Join(".git", "hooks", "pre-commit"), []byte("#!/bin/sh\ntrue\n"), 0755)
+	crypto(t, "", "git", "init")
+	if b, _ := ioutil.ReadFile(filepath.Join(".git", "hooks", "pre-commit")); string(b) != "#!/bin/sh\ntrue\n" {
+		t.Errorf("The pre-commit hook was replaced with %q.", b)
+	}
+	if f := gitRun(t, "config", "filter.crypto.required"); f != "true\n" {
+		t.Errorf("filter.crypto.required is %q.", f)
+	}
+}
+
+func TestVault(t *testing.T) {
+	dir := t.TempDir()
+	passFile := filepath.Join(dir, "vault_pass")
+	ioutil.WriteFile(passFile, []byte("qwerty\n"), 0600)
+	vault := filepath.Join("..", "..", "ansiblevault", "testdata", "secrets-1.1.yml")
+	plain := "db_password: hunter2\napi_token: 0123456789abcdef\n"
+
+	enc := crypto(t, "", append([]string{"vault", "-vault-password-file", passFile}, append(padFlags, vault)...)...)
+	k := &keyFlags{key: "qwerty", mode: "pad", pad: padFlags[5]}
+	c, err := k.cipher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := c.Decrypt([]byte(enc)); err != nil || string(b) != plain {
+		t.Errorf("Converted vault file decrypts to %q, %v.", b, err)
+	}
+
+	out := crypto(t, enc, append([]string{"vault", "-export", "-vault-id", "prod"}, padFlags...)...)
+	if !strings.HasPrefix(out, "$ANSIBLE_VAULT;1.2;AES256;prod\n") {
+		t.Errorf("Unexpected vault file:\n%s", out)
+	}
+	// Without -vault-password-file, the key is the vault password.
+	if b, err := ansiblevault.New([]byte("qwerty")).Decrypt([]byte(out)); err != nil || string(b) != plain {
+		t.Errorf("Exported vault file decrypts to %q, %v.", b, err)
+	}
+
+	if err := run(append([]string{"vault"}, padFlags...), strings.NewReader(enc), ioutil.Discard); err == nil {
+		t.Errorf("vault converts a file that is not an Ansible Vault file.")
+	}
+}
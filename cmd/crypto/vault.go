@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+
+	"github.com/andmarios/crypto/ansiblevault"
+)
+
+// runVault converts Ansible Vault files to files encrypted with the key
+// flags (saltsecret by default), or back with -export.
+func runVault(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("vault", flag.ContinueOnError)
+	keys := addKeyFlags(fs)
+	export := fs.Bool("export", false, "convert a file encrypted with the key to an Ansible Vault file")
+	passwordFile := fs.String("vault-password-file", "", "read the vault password from `file` (default the key)")
+	id := fs.String("vault-id", "", "label exported files with the vault `id` (format 1.2)")
+	output := fs.String("o", "", "write to `file` instead of stdout")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("Usage: crypto vault [flags] [file]\n"))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return errors.New("vault takes at most one file")
+	}
+
+	c, err := keys.cipher()
+	if err != nil {
+		return err
+	}
+	var password []byte
+	if *passwordFile != "" {
+		// Like ansible-vault, ignore surrounding whitespace.
+		if password, err = ioutil.ReadFile(*passwordFile); err != nil {
+			return err
+		}
+		password = bytes.TrimSpace(password)
+	} else if password, err = keys.secret(); err != nil {
+		return err
+	}
+	v := ansiblevault.New(password)
+	v.ID = *id
+
+	var in []byte
+	if fs.NArg() == 1 {
+		in, err = ioutil.ReadFile(fs.Arg(0))
+	} else {
+		in, err = ioutil.ReadAll(stdin)
+	}
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+	if *export {
+		if out, err = c.Decrypt(in); err == nil {
+			out, err = v.Encrypt(out)
+		}
+	} else {
+		if !ansiblevault.IsEncrypted(in) {
+			return errors.New("input is not an Ansible Vault file")
+		}
+		if out, err = v.Decrypt(in); err == nil {
+			out, err = c.Encrypt(out)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		return ioutil.WriteFile(*output, out, 0600)
+	}
+	_, err = stdout.Write(out)
+	return err
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"flag"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/andmarios/crypto/zipaes"
+)
+
+// runZip writes files and directories to a WinZip AES encrypted zip archive,
+// which 7-Zip, WinZip and others can open with the key as password.
+func runZip(args []string, stdin io.Reader, stdout io.Writer) (err error) {
+	fs := flag.NewFlagSet("zip", flag.ContinueOnError)
+	keys := addSecretFlags(fs)
+	output := fs.String("o", "", "write to `file` instead of stdout")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("Usage: crypto zip [flags] path...\n"))
+		fs.PrintDefaults()
+	}
+	if err = fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("zip needs files or directories to archive")
+	}
+
+	password, err := keys.secret()
+	if err != nil {
+		return err
+	}
+	out := stdout
+	if *output != "" {
+		var f *os.File
+		if f, err = os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600); err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		out = f
+	}
+	w := zipaes.NewWriter(out, string(password))
+	for _, p := range fs.Args() {
+		if err = addZip(w, p); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// addZip adds the file or directory tree at root, named by its path relative
+// to the parent of root.
+func addZip(w *zipaes.Writer, root string) error {
+	base := filepath.Dir(filepath.Clean(root))
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() && !fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		fh, err := zip.FileInfoHeader(fi)
+		if err != nil {
+			return err
+		}
+		fh.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			fh.Name += "/"
+			_, err = w.CreateHeader(fh)
+			return err
+		}
+		fh.Method = zip.Deflate
+		zf, err := w.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(zf, f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+}
@@ -0,0 +1,218 @@
+/*
+Package ff1 implements the FF1 format-preserving encryption mode of NIST
+SP 800-38G with AES-256.
+
+Format-preserving encryption maps a string of numerals to another string of
+the same length and radix: a 10 digits account number encrypts to another 10
+digits number. It is deterministic and, since the output has no room for a
+nonce or a tag, it is neither randomized nor authenticated. A tweak, a public
+value such as the name of the field, can be used to make the same plaintext
+encrypt differently in different contexts.
+
+Numerals are written with the digits 0-9, followed by a-z and A-Z, so the
+radix may be from 2 to 62. For radix 10 strings are plain decimal numbers,
+for radix 16 lower case hexadecimal. NIST requires radix^length to be at
+least one million; shorter strings are rejected.
+
+Keys are 32 bytes. New builds them from a key and a pad exactly like
+padsecret.New does.
+*/
+package ff1
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/andmarios/crypto/nacl/padsecret"
+)
+
+// Alphabet holds the numerals, in order of value.
+const Alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+const (
+	minRadix  = 2
+	maxRadix  = len(Alphabet)
+	minDomain = 1000000
+	rounds    = 10
+	blockSize = aes.BlockSize
+)
+
+// maxLen is the longest input and tweak FF1 allows. It is typed so that the
+// comparisons compile where int has 32 bits.
+const maxLen uint64 = math.MaxUint32
+
+// A FF1 holds the instance's block cipher and radix.
+type FF1 struct {
+	block cipher.Block
+	radix int
+}
+
+// New creates a new FF1 instance for strings of the given radix. key and pad
+// are combined into a 32 bytes key like padsecret.New does.
+func New(key, pad string, radix int) (*FF1, error) {
+	k, err := padsecret.Key(key, pad)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromKey(k, radix)
+}
+
+// NewFromKey creates a new FF1 instance for strings of the given radix from a
+// raw 32 bytes key.
+func NewFromKey(key *[32]byte, radix int) (*FF1, error) {
+	if radix < minRadix || radix > maxRadix {
+		return nil, errors.New("ff1 radix should be between 2 and 62")
+	}
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return &FF1{block, radix}, nil
+}
+
+// Encrypt encrypts the numeral string x with tweak, which may be nil, and
+// returns a numeral string of the same length and radix.
+func (c FF1) Encrypt(x string, tweak []byte) (string, error) {
+	return c.crypt(x, tweak, true)
+}
+
+// Decrypt decrypts the numeral string x, encrypted with tweak.
+func (c FF1) Decrypt(x string, tweak []byte) (string, error) {
+	return c.crypt(x, tweak, false)
+}
+
+func (c FF1) crypt(x string, tweak []byte, encrypt bool) (string, error) {
+	n := len(x)
+	if n < 2 || uint64(n) > maxLen {
+		return "", errors.New("ff1 input length out of range")
+	}
+	domain := new(big.Int).Exp(big.NewInt(int64(c.radix)), big.NewInt(int64(n)), nil)
+	if domain.Cmp(big.NewInt(minDomain)) < 0 {
+		return "", errors.New("ff1 input too short for its radix")
+	}
+	for _, r := range x {
+		if i := strings.IndexRune(Alphabet, r); i < 0 || i >= c.radix {
+			return "", errors.New("ff1 input has numerals outside the radix")
+		}
+	}
+	if uint64(len(tweak)) > maxLen {
+		return "", errors.New("ff1 tweak too long")
+	}
+
+	t := len(tweak)
+	u := n / 2
+	v := n - u
+	A, B := x[:u], x[u:]
+
+	// b is the number of bytes of the numerals in B, d the bytes taken from the PRF.
+	b := int(math.Ceil(math.Ceil(float64(v)*math.Log2(float64(c.radix))) / 8))
+	d := 4*((b+3)/4) + 4
+
+	P := []byte{1, 2, 1,
+		byte(c.radix >> 16), byte(c.radix >> 8), byte(c.radix),
+		10, byte(u),
+		byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n),
+		byte(t >> 24), byte(t >> 16), byte(t >> 8), byte(t)}
+
+	pad := (-t - b - 1) % blockSize
+	if pad < 0 {
+		pad += blockSize
+	}
+	Q := make([]byte, t+pad+1+b)
+	copy(Q, tweak)
+
+	radix := big.NewInt(int64(c.radix))
+	modU := new(big.Int).Exp(radix, big.NewInt(int64(u)), nil)
+	modV := new(big.Int).Exp(radix, big.NewInt(int64(v)), nil)
+
+	for j := 0; j < rounds; j++ {
+		i := j
+		src := B
+		if !encrypt {
+			i = rounds - 1 - j
+			src = A
+		}
+
+		Q[t+pad] = byte(i)
+		num := c.num(src).Bytes()
+		for k := t + pad + 1; k < len(Q); k++ {
+			Q[k] = 0
+		}
+		copy(Q[len(Q)-len(num):], num)
+
+		y := new(big.Int).SetBytes(c.expand(c.prf(P, Q), d))
+
+		m, mod := u, modU
+		if i%2 == 1 {
+			m, mod = v, modV
+		}
+		var z *big.Int
+		if encrypt {
+			z = new(big.Int).Add(c.num(A), y)
+		} else {
+			z = new(big.Int).Sub(c.num(B), y)
+		}
+		z.Mod(z, mod)
+		C := c.str(z, m)
+
+		if encrypt {
+			A, B = B, C
+		} else {
+			A, B = C, A
+		}
+	}
+	return A + B, nil
+}
+
+// prf is the CBC-MAC of P || Q with a zero IV.
+func (c FF1) prf(P, Q []byte) []byte {
+	r := make([]byte, blockSize)
+	for _, in := range [][]byte{P, Q} {
+		for k := 0; k < len(in); k += blockSize {
+			for l := 0; l < blockSize; l++ {
+				r[l] ^= in[k+l]
+			}
+			c.block.Encrypt(r, r)
+		}
+	}
+	return r
+}
+
+// expand extends the PRF output R to d bytes:
+// R || CIPH(R xor [1]) || CIPH(R xor [2]) ...
+func (c FF1) expand(R []byte, d int) []byte {
+	S := make([]byte, 0, (d/blockSize+1)*blockSize)
+	S = append(S, R...)
+	block := make([]byte, blockSize)
+	for j := 1; len(S) < d; j++ {
+		copy(block, R)
+		for k := 0; k < 8; k++ {
+			block[blockSize-1-k] ^= byte(uint64(j) >> (8 * k))
+		}
+		c.block.Encrypt(block, block)
+		S = append(S, block...)
+	}
+	return S[:d]
+}
+
+// num returns the value of the numeral string x.
+func (c FF1) num(x string) *big.Int {
+	z, _ := new(big.Int).SetString(x, c.radix)
+	if z == nil {
+		z = new(big.Int)
+	}
+	return z
+}
+
+// str returns z as a numeral string of length m.
+func (c FF1) str(z *big.Int, m int) string {
+	s := z.Text(c.radix)
+	if len(s) < m {
+		s = strings.Repeat("0", m-len(s)) + s
+	}
+	return s
+}
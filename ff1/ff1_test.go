@@ -0,0 +1,93 @@
+package ff1
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// AES-256 samples (7, 8 and 9) of the NIST FF1 examples.
+var nistVectors = []struct {
+	radix      int
+	tweak      string
+	plaintext  string
+	ciphertext string
+}{
+	{10, "", "0123456789", "6657667009"},
+	{10, "39383736353433323130", "0123456789", "1001623463"},
+	{36, "3737373770717273373737", "0123456789abcdefghi", "xs8a0azh2avyalyzuwd"},
+}
+
+func TestNISTVectors(t *testing.T) {
+	k, _ := hex.DecodeString("2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94")
+	var key [32]byte
+	copy(key[:], k)
+
+	for i, v := range nistVectors {
+		c, err := NewFromKey(&key, v.radix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tweak, _ := hex.DecodeString(v.tweak)
+
+		ct, err := c.Encrypt(v.plaintext, tweak)
+		if err != nil || ct != v.ciphertext {
+			t.Errorf("Sample %d: Encrypt() returned '%s', %v, expected '%s'.", i+7, ct, err, v.ciphertext)
+		}
+		pt, err := c.Decrypt(v.ciphertext, tweak)
+		if err != nil || pt != v.plaintext {
+			t.Errorf("Sample %d: Decrypt() returned '%s', %v, expected '%s'.", i+7, pt, err, v.plaintext)
+		}
+	}
+}
+
+func TestPackage(t *testing.T) {
+	if _, err := New("qwerty", "short", 10); err == nil {
+		t.Errorf("New() accepts pad smaller than 32 bytes")
+	}
+	if _, err := New("qwerty", "qwertyuiopasdfghjklzxcvbnm123456", 63); err == nil {
+		t.Errorf("New() accepts radix larger than 62.")
+	}
+
+	c, err := New("qwerty", "qwertyuiopasdfghjklzxcvbnm123456", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"000000", "4111111111111111", "1234567890123456789012345678901234567890"} {
+		enc, err := c.Encrypt(id, []byte("accounts"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(enc) != len(id) {
+			t.Errorf("Encrypted '%s' to '%s' of different length.", id, enc)
+		}
+		for _, r := range enc {
+			if r < '0' || r > '9' {
+				t.Errorf("Encrypted '%s' to '%s', which is not numeric.", id, enc)
+			}
+		}
+		if enc2, _ := c.Encrypt(id, []byte("cards")); enc2 == enc {
+			t.Errorf("Tweak does not change the ciphertext of '%s'.", id)
+		}
+		dec, err := c.Decrypt(enc, []byte("accounts"))
+		if err != nil || dec != id {
+			t.Errorf("Decrypt() returned '%s', %v, expected '%s'.", dec, err, id)
+		}
+	}
+
+	if _, err = c.Encrypt("12345", nil); err == nil {
+		t.Errorf("Encrypt() accepts a domain smaller than one million.")
+	}
+	if _, err = c.Encrypt("12345a", nil); err == nil {
+		t.Errorf("Encrypt() accepts numerals outside the radix.")
+	}
+
+	b62, _ := New("qwerty", "qwertyuiopasdfghjklzxcvbnm123456", 62)
+	enc, err := b62.Encrypt("HelloWorld42", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec, _ := b62.Decrypt(enc, nil); dec != "HelloWorld42" {
+		t.Errorf("Radix 62 round trip returned '%s'.", dec)
+	}
+}
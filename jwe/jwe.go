@@ -0,0 +1,299 @@
+/*
+Package jwe implements JWE (RFC 7516) compact serialization for symmetric keys.
+
+Two key management algorithms are supported:
+
+	dir                  a shared 32 bytes key, like the one padsecret uses
+	PBES2-HS512+A256KW   a password, like the one saltsecret uses; a content
+	                     key is wrapped with a key derived by PBKDF2-SHA512
+
+and two content encryption algorithms:
+
+	A256GCM   AES-256 in Galois/Counter mode
+	XC20P     XChaCha20-Poly1305
+
+Optionally the plaintext is compressed with DEFLATE ("zip":"DEF") before
+encrypting, the JOSE equivalent of padsecret's and saltsecret's compression.
+
+Decrypt only accepts tokens that use the instance's key management algorithm,
+so a password can never be used as a direct key or vice versa.
+*/
+package jwe
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Key management algorithms.
+const (
+	Dir   = "dir"
+	PBES2 = "PBES2-HS512+A256KW"
+)
+
+// Content encryption algorithms.
+const (
+	A256GCM = "A256GCM"
+	XC20P   = "XC20P"
+)
+
+// DEF is the value of the "zip" header for DEFLATE compressed plaintexts.
+const DEF = "DEF"
+
+// DefaultP2C is the PBKDF2 iteration count used by NewPBES2.
+const DefaultP2C = 210000
+
+// DefaultMaxInflate is the MaxInflate of new instances.
+const DefaultMaxInflate = 10 << 20
+
+const (
+	keySize  = 32
+	saltSize = 16
+
+	minP2C = 1000
+	maxP2C = 1000000
+)
+
+// ErrInvalid is returned for tokens that are malformed, use unsupported
+// algorithms, were created with another key or were tampered with.
+var ErrInvalid = errors.New("invalid jwe token")
+
+var b64 = base64.RawURLEncoding
+
+type header struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc"`
+	Zip string `json:"zip,omitempty"`
+	P2S string `json:"p2s,omitempty"`
+	P2C int    `json:"p2c,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Cty string `json:"cty,omitempty"`
+
+	Crit []string `json:"crit,omitempty"`
+}
+
+// A JWE holds the instance's key, algorithms and compression settings.
+// P2C is the PBKDF2 iteration count for PBES2; you may set it explicitly after
+// creating a JWE. Decrypt refuses tokens with a higher count, so that tokens
+// can not make it spend more time than the instance would. MaxInflate is the
+// largest plaintext, in bytes, Decrypt inflates a compressed token to. Kid
+// and Cty, if set, are added to the header of new tokens.
+type JWE struct {
+	alg        string
+	enc        string
+	key        []byte
+	compress   bool
+	P2C        int
+	MaxInflate int64
+	Kid        string
+	Cty        string
+}
+
+// NewDir creates a new JWE instance that encrypts directly with key ("dir"),
+// using the content encryption algorithm enc. compress indicates whether the
+// plaintext should be compressed (DEFLATE) before encrypting.
+// You may get a key from a key and a pad with padsecret.Key.
+func NewDir(key *[32]byte, enc string, compress bool) (*JWE, error) {
+	if enc != A256GCM && enc != XC20P {
+		return nil, errors.New("unsupported content encryption algorithm " + enc)
+	}
+	k := make([]byte, keySize)
+	copy(k, key[:])
+	return &JWE{Dir, enc, k, compress, 0, DefaultMaxInflate, "", ""}, nil
+}
+
+// NewPBES2 creates a new JWE instance that wraps a random content key with a key
+// derived from password ("PBES2-HS512+A256KW"), using the content encryption
+// algorithm enc. compress indicates whether the plaintext should be compressed
+// (DEFLATE) before encrypting.
+func NewPBES2(password []byte, enc string, compress bool) (*JWE, error) {
+	if enc != A256GCM && enc != XC20P {
+		return nil, errors.New("unsupported content encryption algorithm " + enc)
+	}
+	return &JWE{PBES2, enc, password, compress, DefaultP2C, DefaultMaxInflate, "", ""}, nil
+}
+
+// Encrypt encrypts msg and returns it as a JWE in compact serialization.
+func (c JWE) Encrypt(msg []byte) (string, error) {
+	h := header{Alg: c.alg, Enc: c.enc, Kid: c.Kid, Cty: c.Cty}
+
+	var cek, encryptedKey []byte
+	switch c.alg {
+	case Dir:
+		cek = c.key
+	case PBES2:
+		if c.P2C < minP2C || c.P2C > maxP2C {
+			return "", errors.New("PBES2 iteration count out of range")
+		}
+		salt := make([]byte, saltSize)
+		cek = make([]byte, keySize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return "", err
+		}
+		if _, err := io.ReadFull(rand.Reader, cek); err != nil {
+			return "", err
+		}
+		h.P2S = b64.EncodeToString(salt)
+		h.P2C = c.P2C
+		var err error
+		encryptedKey, err = wrap(pbes2Key(c.key, salt, c.P2C), cek)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if c.compress {
+		h.Zip = DEF
+		msg = deflate(msg)
+	}
+
+	hb, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+	protected := b64.EncodeToString(hb)
+
+	aead, err := newAEAD(c.enc, cek)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, iv); err != nil {
+		return "", err
+	}
+	ct, tag := seal(aead, iv, msg, protected)
+
+	return strings.Join([]string{
+		protected,
+		b64.EncodeToString(encryptedKey),
+		b64.EncodeToString(iv),
+		b64.EncodeToString(ct),
+		b64.EncodeToString(tag),
+	}, "."), nil
+}
+
+// Decrypt decrypts a JWE in compact serialization and returns the plaintext.
+func (c JWE) Decrypt(token string) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return nil, ErrInvalid
+	}
+	var raw [4][]byte
+	for i, p := range parts[1:] {
+		b, err := b64.DecodeString(p)
+		if err != nil {
+			return nil, ErrInvalid
+		}
+		raw[i] = b
+	}
+	encryptedKey, iv, ct, tag := raw[0], raw[1], raw[2], raw[3]
+
+	hb, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	var h header
+	if err = json.Unmarshal(hb, &h); err != nil {
+		return nil, ErrInvalid
+	}
+	if h.Alg != c.alg || (h.Enc != A256GCM && h.Enc != XC20P) || len(h.Crit) > 0 {
+		return nil, ErrInvalid
+	}
+	if h.Zip != "" && h.Zip != DEF {
+		return nil, ErrInvalid
+	}
+
+	var cek []byte
+	switch c.alg {
+	case Dir:
+		if len(encryptedKey) != 0 {
+			return nil, ErrInvalid
+		}
+		cek = c.key
+	case PBES2:
+		salt, err := b64.DecodeString(h.P2S)
+		if err != nil || len(salt) < 8 || h.P2C < minP2C || h.P2C > c.P2C || h.P2C > maxP2C {
+			return nil, ErrInvalid
+		}
+		cek, err = unwrap(pbes2Key(c.key, salt, h.P2C), encryptedKey)
+		if err != nil || len(cek) != keySize {
+			return nil, ErrInvalid
+		}
+	}
+
+	aead, err := newAEAD(h.Enc, cek)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aead.NonceSize() || len(tag) != aead.Overhead() {
+		return nil, ErrInvalid
+	}
+	out, err := aead.Open(nil, iv, append(ct, tag...), []byte(parts[0]))
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	if h.Zip == DEF {
+		r := flate.NewReader(bytes.NewReader(out))
+		defer r.Close()
+		out, err = io.ReadAll(io.LimitReader(r, c.MaxInflate+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(out)) > c.MaxInflate {
+			return nil, errors.New("jwe plaintext is larger than MaxInflate")
+		}
+	}
+	return out, nil
+}
+
+func newAEAD(enc string, cek []byte) (cipher.AEAD, error) {
+	switch enc {
+	case A256GCM:
+		block, err := aes.NewCipher(cek)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case XC20P:
+		return chacha20poly1305.NewX(cek)
+	}
+	return nil, errors.New("unsupported content encryption algorithm " + enc)
+}
+
+// seal encrypts msg and returns the ciphertext and the authentication tag. The
+// additional authenticated data is the ASCII of the encoded protected header.
+func seal(aead cipher.AEAD, iv, msg []byte, protected string) (ct, tag []byte) {
+	out := aead.Seal(nil, iv, msg, []byte(protected))
+	n := len(out) - aead.Overhead()
+	return out[:n], out[n:]
+}
+
+// pbes2Key derives the key encryption key for PBES2-HS512+A256KW.
+func pbes2Key(password, salt []byte, p2c int) []byte {
+	s := make([]byte, 0, len(PBES2)+1+len(salt))
+	s = append(s, PBES2...)
+	s = append(s, 0)
+	s = append(s, salt...)
+	return pbkdf2.Key(password, s, p2c, keySize, sha512.New)
+}
+
+func deflate(msg []byte) []byte {
+	var b bytes.Buffer
+	w, _ := flate.NewWriter(&b, flate.DefaultCompression)
+	w.Write(msg)
+	w.Close()
+	return b.Bytes()
+}
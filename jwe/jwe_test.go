@@ -0,0 +1,195 @@
+package jwe
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/andmarios/crypto/nacl/padsecret"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func mustB64(s string) []byte {
+	b, err := b64.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// RFC 3394, section 4.6: wrap 256 bits of key data with a 256-bit KEK.
+func TestKeyWrapVector(t *testing.T) {
+	kek := mustHex("000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F")
+	key := mustHex("00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F")
+	expected := mustHex("28C9F404C4B810F4 CBCCB35CFB87F826 3F5786E2D80ED326 CBC7F0E71A99F43B FB988B9B7A02DD21")
+
+	wrapped, err := wrap(kek, key)
+	if err != nil || !bytes.Equal(wrapped, expected) {
+		t.Errorf("wrap() returned %x, %v.", wrapped, err)
+	}
+	unwrapped, err := unwrap(kek, expected)
+	if err != nil || !bytes.Equal(unwrapped, key) {
+		t.Errorf("unwrap() returned %x, %v.", unwrapped, err)
+	}
+	expected[0] ^= 0x01
+	if _, err = unwrap(kek, expected); err == nil {
+		t.Errorf("unwrap() accepts tampered key.")
+	}
+}
+
+// RFC 7516, appendix A.1: A256GCM content encryption.
+func TestA256GCMVector(t *testing.T) {
+	protected := "eyJhbGciOiJSU0EtT0FFUCIsImVuYyI6IkEyNTZHQ00ifQ"
+	cek := []byte{177, 161, 244, 128, 84, 143, 225, 115, 63, 180, 3, 255, 107, 154,
+		212, 246, 138, 7, 110, 91, 112, 46, 34, 105, 47, 130, 203, 46, 122,
+		234, 64, 252}
+	iv := mustB64("48V1_ALb6US04U3b")
+	msg := []byte("The true sign of intelligence is not knowledge but imagination.")
+
+	aead, err := newAEAD(A256GCM, cek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, tag := seal(aead, iv, msg, protected)
+	if b64.EncodeToString(ct) != "5eym8TW_c8SuK0ltJ3rpYIzOeDQz7TALvtu6UG9oMo4vpzs9tX_EFShS8iB7j6jiSdiwkIr3ajwQzaBtQD_A" {
+		t.Errorf("Ciphertext did not match: %s", b64.EncodeToString(ct))
+	}
+	if b64.EncodeToString(tag) != "XFBoMYUZodetZdvTiFvSkQ" {
+		t.Errorf("Authentication tag did not match: %s", b64.EncodeToString(tag))
+	}
+}
+
+// RFC 7520, section 5.3: PBES2-HS512+A256KW key encryption.
+func TestPBES2Vector(t *testing.T) {
+	password := []byte("entrap_o–peter_long–credit_tun")
+	cek := mustB64("uwsjJXaBK407Qaf0_zpcpmr1Cs0CC50hIUEyGNEt3m0")
+	salt := mustB64("8Q1SzinasR3xchYz6ZZcHA")
+
+	wrapped, err := wrap(pbes2Key(password, salt, 8192), cek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b64.EncodeToString(wrapped) != "d3qNhUWfqheyPp4H8sjOWsDYajoej4c5Je6rlUtFPWdgtURtmeDV1g" {
+		t.Errorf("Encrypted key did not match: %s", b64.EncodeToString(wrapped))
+	}
+}
+
+func TestPackage(t *testing.T) {
+	key, err := padsecret.Key("qwerty", "qwertyuiopasdfghjklzxcvbnm123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, _ := NewDir(key, A256GCM, false)
+	dirX, _ := NewDir(key, XC20P, true)
+	pbes2, _ := NewPBES2([]byte("qwerty"), A256GCM, true)
+	pbes2.P2C = minP2C
+	pbes2X, _ := NewPBES2([]byte("qwerty"), XC20P, false)
+	pbes2X.P2C = minP2C
+
+	msg := []byte(`{"data":"this is a secret message","pad":"` + strings.Repeat("a", 200) + `"}`)
+	for _, c := range []*JWE{dir, dirX, pbes2, pbes2X} {
+		token, err := c.Encrypt(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts := strings.Split(token, ".")
+		if len(parts) != 5 {
+			t.Fatalf("%s/%s: token '%s' is not in compact serialization.", c.alg, c.enc, token)
+		}
+		var h header
+		json.Unmarshal(mustB64(parts[0]), &h)
+		if h.Alg != c.alg || h.Enc != c.enc || (h.Zip == DEF) != c.compress {
+			t.Errorf("%s/%s: unexpected header %+v.", c.alg, c.enc, h)
+		}
+
+		dec, err := c.Decrypt(token)
+		if err != nil {
+			t.Errorf("%s/%s: Decrypt() failed: %v", c.alg, c.enc, err)
+		}
+		if !bytes.Equal(dec, msg) {
+			t.Errorf("%s/%s: Decoded message '%s' differs from encoded message '%s'.", c.alg, c.enc, dec, msg)
+		}
+
+		tampered := []byte(token)
+		tampered[len(token)-3] ^= 0x01
+		if _, err = c.Decrypt(string(tampered)); err == nil {
+			t.Errorf("%s/%s: Decrypt() accepts tampered token.", c.alg, c.enc)
+		}
+	}
+
+	// The same key must not be usable across key management algorithms.
+	token, _ := dir.Encrypt(msg)
+	if _, err = pbes2.Decrypt(token); err != ErrInvalid {
+		t.Errorf("PBES2 instance accepts a dir token.")
+	}
+
+	other, _ := NewPBES2([]byte("azerty"), A256GCM, false)
+	token, _ = pbes2.Encrypt(msg)
+	if _, err = other.Decrypt(token); err != ErrInvalid {
+		t.Errorf("Decrypt() with wrong password returned %v.", err)
+	}
+
+	// Tokens may not ask for more PBKDF2 iterations than the instance uses.
+	costly, _ := NewPBES2([]byte("qwerty"), A256GCM, false)
+	costly.P2C = 2 * minP2C
+	token, _ = costly.Encrypt(msg)
+	if _, err = pbes2.Decrypt(token); err != ErrInvalid {
+		t.Errorf("Decrypt() accepts a token with a higher p2c: %v.", err)
+	}
+	if _, err = costly.Decrypt(token); err != nil {
+		t.Errorf("Decrypt() refuses a token with its own p2c: %v.", err)
+	}
+
+	if _, err = NewDir(key, "A128CBC-HS256", false); err == nil {
+		t.Errorf("NewDir() accepts unsupported content encryption algorithm.")
+	}
+}
+
+func TestDeflate(t *testing.T) {
+	key, _ := padsecret.Key("qwerty", "qwertyuiopasdfghjklzxcvbnm123456")
+	c, _ := NewDir(key, A256GCM, true)
+	msg := bytes.Repeat([]byte("compress me "), 100)
+	token, _ := c.Encrypt(msg)
+
+	// Decrypt by hand to check the plaintext is raw DEFLATE, as RFC 7516 requires.
+	parts := strings.Split(token, ".")
+	aead, _ := newAEAD(A256GCM, key[:])
+	out, err := aead.Open(nil, mustB64(parts[2]), append(mustB64(parts[3]), mustB64(parts[4])...), []byte(parts[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) >= len(msg) {
+		t.Errorf("Compressed plaintext is not smaller than the message.")
+	}
+	inflated, err := io.ReadAll(flate.NewReader(bytes.NewReader(out)))
+	if err != nil || !bytes.Equal(inflated, msg) {
+		t.Errorf("Plaintext is not raw DEFLATE: %v", err)
+	}
+}
+
+func TestMaxInflate(t *testing.T) {
+	key, _ := padsecret.Key("qwerty", "qwertyuiopasdfghjklzxcvbnm123456")
+	c, _ := NewDir(key, A256GCM, true)
+	token, _ := c.Encrypt(make([]byte, 1<<20))
+	if len(token) > 10000 {
+		t.Fatalf("Token of %d bytes.", len(token))
+	}
+	if out, err := c.Decrypt(token); err != nil || len(out) != 1<<20 {
+		t.Errorf("Decrypt() returned %d bytes, %v.", len(out), err)
+	}
+	c.MaxInflate = 1<<20 - 1
+	if _, err := c.Decrypt(token); err == nil {
+		t.Errorf("Decrypt() inflates past MaxInflate.")
+	}
+}
@@ -0,0 +1,71 @@
+package jwe
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// defaultIV is the initial value of RFC 3394.
+var defaultIV = []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+
+// wrap wraps key with kek using the AES Key Wrap algorithm (RFC 3394).
+func wrap(kek, key []byte) ([]byte, error) {
+	if len(key)%8 != 0 || len(key) < 16 {
+		return nil, errors.New("key to wrap must be a multiple of 8 bytes")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(key) / 8
+	out := make([]byte, 8+len(key))
+	copy(out, defaultIV)
+	copy(out[8:], key)
+
+	b := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b, out[:8])
+			copy(b[8:], out[8*i:8*i+8])
+			block.Encrypt(b, b)
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(out, binary.BigEndian.Uint64(b)^t)
+			copy(out[8*i:], b[8:])
+		}
+	}
+	return out, nil
+}
+
+// unwrap unwraps a key wrapped with kek by wrap.
+func unwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped)%8 != 0 || len(wrapped) < 24 {
+		return nil, errors.New("wrapped key must be a multiple of 8 bytes")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(wrapped)/8 - 1
+	out := make([]byte, len(wrapped))
+	copy(out, wrapped)
+
+	b := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(b, binary.BigEndian.Uint64(out)^t)
+			copy(b[8:], out[8*i:8*i+8])
+			block.Decrypt(b, b)
+			copy(out, b[:8])
+			copy(out[8*i:], b[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(out[:8], defaultIV) != 1 {
+		return nil, errors.New("key unwrap integrity check failed")
+	}
+	return out[8:], nil
+}
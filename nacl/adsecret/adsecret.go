@@ -0,0 +1,82 @@
+/*
+Package adsecret binds associated data to messages encrypted by padsecret
+or saltsecret.
+
+NaCl's secretbox does not support associated data, so adsecret prepends a
+SHA-256 digest of the associated data to the plaintext before encrypting it.
+When decrypting, the digest inside the (authenticated) plaintext is compared
+against the digest of the associated data the caller expects. A message
+encrypted for one context (a row, a request, a key name) will thus fail to
+decrypt in any other context.
+
+The associated data itself is not part of the encrypted message; the receiver
+has to know it.
+*/
+package adsecret
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+)
+
+// A Cipher encrypts and decrypts whole messages. padsecret.PadSecret and
+// saltsecret.SaltSecret (and pointers to them) satisfy it.
+type Cipher interface {
+	Encrypt(msg []byte) ([]byte, error)
+	Decrypt(msg []byte) ([]byte, error)
+}
+
+// ErrMismatch is returned by Decrypt when a message decrypts correctly but
+// was encrypted with different associated data.
+var ErrMismatch = errors.New("associated data does not match")
+
+const digestSize = sha256.Size
+
+// An ADSecret wraps a Cipher so that every message is bound to associated data.
+type ADSecret struct {
+	c Cipher
+}
+
+// New creates a new ADSecret instance that encrypts with c.
+func New(c Cipher) *ADSecret {
+	return &ADSecret{c}
+}
+
+// Encrypt encrypts msg with the underlying Cipher and binds ad to it.
+func (c ADSecret) Encrypt(msg, ad []byte) ([]byte, error) {
+	digest := sha256.Sum256(ad)
+	in := make([]byte, 0, digestSize+len(msg))
+	in = append(in, digest[:]...)
+	in = append(in, msg...)
+	return c.c.Encrypt(in)
+}
+
+// Decrypt decrypts msg with the underlying Cipher and verifies that it was
+// encrypted with the same associated data ad.
+func (c ADSecret) Decrypt(msg, ad []byte) ([]byte, error) {
+	out, err := c.c.Decrypt(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) < digestSize {
+		return nil, errors.New("decrypted message length too short")
+	}
+	digest := sha256.Sum256(ad)
+	if subtle.ConstantTimeCompare(out[:digestSize], digest[:]) != 1 {
+		return nil, ErrMismatch
+	}
+	return out[digestSize:], nil
+}
+
+// Join encodes a list of fields as associated data. Each field is length
+// prefixed, so that, for example, ("ab", "c") and ("a", "bc") differ.
+func Join(fields ...string) []byte {
+	var ad []byte
+	for _, f := range fields {
+		n := len(f)
+		ad = append(ad, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+		ad = append(ad, f...)
+	}
+	return ad
+}
@@ -0,0 +1,53 @@
+package adsecret
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/andmarios/crypto/nacl/padsecret"
+)
+
+func TestPackage(t *testing.T) {
+	p, err := padsecret.New("qwerty", "qwertyuiopasdfghjklzxcvbnm123456", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New(p)
+
+	msg := []byte("hello world")
+	enc, err := c.Encrypt(msg, []byte("row 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec, err := c.Decrypt(enc, []byte("row 1"))
+	if err != nil {
+		t.Errorf("Decrypt() failed: %v", err)
+	}
+	if !bytes.Equal(dec, msg) {
+		t.Errorf("Decoded message '%s' differs from encoded message '%s'.", dec, msg)
+	}
+
+	if _, err = c.Decrypt(enc, []byte("row 2")); err != ErrMismatch {
+		t.Errorf("Decrypt() with wrong associated data returned %v, expected ErrMismatch.", err)
+	}
+
+	enc[len(enc)-1] ^= 0x01
+	if _, err = c.Decrypt(enc, []byte("row 1")); err == nil {
+		t.Errorf("Decrypt() accepts tampered message.")
+	}
+
+	short, _ := p.Encrypt([]byte("short"))
+	if _, err = c.Decrypt(short, nil); err == nil {
+		t.Errorf("Decrypt() accepts message without associated data digest.")
+	}
+}
+
+func TestJoin(t *testing.T) {
+	if bytes.Equal(Join("ab", "c"), Join("a", "bc")) {
+		t.Errorf("Join() is ambiguous.")
+	}
+	if !bytes.Equal(Join("a", "b"), Join("a", "b")) {
+		t.Errorf("Join() is not deterministic.")
+	}
+}
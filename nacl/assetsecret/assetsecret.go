@@ -0,0 +1,285 @@
+/*
+Package assetsecret encrypts asset directories at build time, to be
+committed and embedded, and decrypts them at runtime.
+
+Generate, usually run by "crypto assets" from a go:generate directive,
+encrypts every file of a source directory with padsecret or saltsecret into
+an output directory and writes a manifest, manifest.json, with the size of
+each file and the SHA-256 digest of its encrypted form:
+
+	//go:generate go run github.com/andmarios/crypto/cmd/crypto assets -keyfile assets.key -o assets.enc assets
+	//go:embed assets.enc
+	var encrypted embed.FS
+
+The manifest is sealed: a digest of all its entries is encrypted with the
+same key, so that encrypted files can not be swapped, removed or added
+without detection. Files that did not change keep their ciphertext, so
+regenerating assets does not churn the repository.
+
+Open verifies the manifest and returns an fs.FS that decrypts the files and
+checks them against the manifest, for http.FileServer, template.ParseFS and
+the like.
+*/
+package assetsecret
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/andmarios/crypto/nacl/adsecret"
+	"github.com/andmarios/crypto/nacl/fssecret"
+)
+
+// ManifestName is the name of the manifest in an output directory.
+const ManifestName = "manifest.json"
+
+// ErrManifest is returned when the manifest does not match the key or the
+// files.
+var ErrManifest = errors.New("asset manifest does not verify")
+
+// A Manifest lists the files of an encrypted asset directory.
+type Manifest struct {
+	Version string          `json:"version"`
+	Files   map[string]File `json:"files"`
+	Seal    []byte          `json:"seal"`
+}
+
+// A File is an entry of a Manifest: the size of the plaintext and the SHA-256
+// digest (hex) of the encrypted file.
+type File struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// digest returns the digest of the manifest entries, which the seal holds.
+func (m *Manifest) digest() []byte {
+	names := make([]string, 0, len(m.Files))
+	for n := range m.Files {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	h.Write(adsecret.Join("assetsecret", m.Version))
+	for _, n := range names {
+		h.Write(adsecret.Join(n, strconv.FormatInt(m.Files[n].Size, 10), m.Files[n].SHA256))
+	}
+	return h.Sum(nil)
+}
+
+func (m *Manifest) verify(c adsecret.Cipher) error {
+	d, err := c.Decrypt(m.Seal)
+	if err != nil || subtle.ConstantTimeCompare(d, m.digest()) != 1 {
+		return ErrManifest
+	}
+	return nil
+}
+
+// Generate encrypts the regular files of src with c, usually a
+// padsecret.PadSecret or saltsecret.SaltSecret, into the directory dst and
+// writes its manifest. Files of a previous run that still decrypt to the
+// same content are kept as they are; files that were removed from src are
+// removed from dst.
+func Generate(dst string, src fs.FS, c adsecret.Cipher) (*Manifest, error) {
+	old := &Manifest{}
+	if b, err := os.ReadFile(filepath.Join(dst, ManifestName)); err == nil {
+		if json.Unmarshal(b, old) != nil || old.verify(c) != nil {
+			old = &Manifest{}
+		}
+	}
+	m := &Manifest{Version: "1", Files: make(map[string]File)}
+	err := fs.WalkDir(src, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		if p == ManifestName {
+			return errors.New("source directory holds a file named " + ManifestName)
+		}
+		data, err := fs.ReadFile(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, filepath.FromSlash(p))
+		enc, err := os.ReadFile(target)
+		if err != nil || !decryptsTo(c, p, enc, data) {
+			if enc, err = fssecret.EncryptFile(c, p, data); err != nil {
+				return err
+			}
+			if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err = os.WriteFile(target, enc, 0644); err != nil {
+				return err
+			}
+		}
+		sum := sha256.Sum256(enc)
+		m.Files[p] = File{Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for p := range old.Files {
+		if _, ok := m.Files[p]; !ok {
+			os.Remove(filepath.Join(dst, filepath.FromSlash(p)))
+		}
+	}
+
+	if old.Seal != nil && subtle.ConstantTimeCompare(m.digest(), old.digest()) == 1 {
+		m.Seal = old.Seal
+	} else if m.Seal, err = c.Encrypt(m.digest()); err != nil {
+		return nil, err
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(dst, 0755); err != nil {
+		return nil, err
+	}
+	return m, os.WriteFile(filepath.Join(dst, ManifestName), append(b, '\n'), 0644)
+}
+
+// decryptsTo reports whether enc is an encryption of data for the asset name.
+func decryptsTo(c adsecret.Cipher, name string, enc, data []byte) bool {
+	pt, err := fssecret.DecryptFile(c, name, enc)
+	return err == nil && bytes.Equal(pt, data)
+}
+
+// An FS decrypts the assets of a directory written by Generate. It
+// implements fs.FS, fs.ReadFileFS, fs.ReadDirFS and fs.StatFS.
+type FS struct {
+	*fssecret.FS
+	manifest *Manifest
+}
+
+// Open verifies the manifest of the asset directory fsys, such as an
+// embed.FS (see fs.Sub) or an os.DirFS, and returns an FS that decrypts its
+// files with c. Files are checked against the manifest when they are read.
+func Open(fsys fs.FS, c adsecret.Cipher) (*FS, error) {
+	b, err := fs.ReadFile(fsys, ManifestName)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err = json.Unmarshal(b, m); err != nil {
+		return nil, errors.New("asset manifest is malformed: " + err.Error())
+	}
+	if err = m.verify(c); err != nil {
+		return nil, err
+	}
+	return &FS{FS: fssecret.New(&checkedFS{fsys, m}, c, nil), manifest: m}, nil
+}
+
+// Manifest returns the verified manifest of the assets.
+func (f *FS) Manifest() *Manifest {
+	return f.manifest
+}
+
+// Check decrypts every asset, so that a broken asset directory can be
+// detected at startup instead of when a file is requested.
+func (f *FS) Check() error {
+	for p := range f.manifest.Files {
+		if _, err := f.ReadFile(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkedFS serves the encrypted files listed in the manifest, after checking
+// their digests, and hides everything else.
+type checkedFS struct {
+	fsys fs.FS
+	m    *Manifest
+}
+
+func (c *checkedFS) Open(name string) (fs.File, error) {
+	if _, ok := c.m.Files[name]; !ok {
+		if !c.isDir(name) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		return c.fsys.Open(name)
+	}
+	data, err := c.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := fs.Stat(c.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return &memFile{Reader: bytes.NewReader(data), fi: fi}, nil
+}
+
+// isDir reports whether name is a directory holding assets.
+func (c *checkedFS) isDir(name string) bool {
+	if name == "." {
+		return true
+	}
+	for p := range c.m.Files {
+		if len(p) > len(name) && p[:len(name)] == name && p[len(name)] == '/' {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *checkedFS) ReadFile(name string) ([]byte, error) {
+	f, ok := c.m.Files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	data, err := fs.ReadFile(c.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != f.SHA256 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: ErrManifest}
+	}
+	return data, nil
+}
+
+func (c *checkedFS) Stat(name string) (fs.FileInfo, error) {
+	if _, ok := c.m.Files[name]; !ok && !c.isDir(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return fs.Stat(c.fsys, name)
+}
+
+func (c *checkedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !c.isDir(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	entries, err := fs.ReadDir(c.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	out := entries[:0]
+	for _, e := range entries {
+		p := path.Join(name, e.Name())
+		if _, ok := c.m.Files[p]; ok || (e.IsDir() && c.isDir(p)) {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// memFile is an encrypted file that was read and checked.
+type memFile struct {
+	*bytes.Reader
+	fi fs.FileInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.fi, nil }
+func (f *memFile) Close() error               { return nil }
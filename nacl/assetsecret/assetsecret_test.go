@@ -0,0 +1,125 @@
+package assetsecret
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/andmarios/crypto/nacl/padsecret"
+	"github.com/andmarios/crypto/nacl/saltsecret"
+)
+
+var c, _ = padsecret.New("qwerty", "qwertyuiopasdfghjklzxcvbnm123456", false)
+
+var assets = fstest.MapFS{
+	"index.html":         {Data: []byte("<h1>hello</h1>")},
+	"css/site.css":       {Data: []byte("body {}")},
+	"templates/a.tmpl":   {Data: []byte("{{.}}")},
+	"templates/b/c.tmpl": {Data: []byte("c")},
+}
+
+func TestGenerateOpen(t *testing.T) {
+	dir := t.TempDir()
+	m, err := Generate(dir, assets, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 4 || m.Files["css/site.css"].Size != 7 {
+		t.Errorf("Unexpected manifest %+v.", m)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "index.html")); bytes.Contains(b, []byte("hello")) {
+		t.Errorf("Generate wrote plaintext.")
+	}
+
+	fsys, err := Open(os.DirFS(dir), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fstest.TestFS(fsys, "index.html", "css/site.css", "templates/a.tmpl", "templates/b/c.tmpl"); err != nil {
+		t.Error(err)
+	}
+	if _, err = fsys.Open(ManifestName); err == nil {
+		t.Errorf("The manifest is served as an asset.")
+	}
+	if err = fsys.Check(); err != nil {
+		t.Error(err)
+	}
+	if _, err = Open(os.DirFS(dir), saltsecret.New([]byte("wrong"), false)); err != ErrManifest {
+		t.Errorf("Expected ErrManifest with a wrong key, got %v.", err)
+	}
+}
+
+func TestRegenerate(t *testing.T) {
+	dir := t.TempDir()
+	Generate(dir, assets, c)
+	read := func(name string) []byte {
+		b, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		return b
+	}
+	index, manifest := read("index.html"), read(ManifestName)
+
+	if _, err := Generate(dir, assets, c); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(index, read("index.html")) || !bytes.Equal(manifest, read(ManifestName)) {
+		t.Errorf("Regenerating unchanged assets changed their ciphertext.")
+	}
+
+	changed := fstest.MapFS{
+		"index.html":   {Data: []byte("<h1>bye</h1>")},
+		"css/site.css": assets["css/site.css"],
+	}
+	if _, err := Generate(dir, changed, c); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(index, read("index.html")) {
+		t.Errorf("Changed asset was not encrypted anew.")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "templates/a.tmpl")); err == nil {
+		t.Errorf("Removed asset was not removed.")
+	}
+	fsys, err := Open(os.DirFS(dir), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := fsys.ReadFile("index.html"); err != nil || string(b) != "<h1>bye</h1>" {
+		t.Errorf("ReadFile returned %q, %v.", b, err)
+	}
+}
+
+func TestTamper(t *testing.T) {
+	dir := t.TempDir()
+	Generate(dir, assets, c)
+
+	// Swapping two encrypted files is detected by the manifest.
+	a, b := filepath.Join(dir, "templates/a.tmpl"), filepath.Join(dir, "templates/b/c.tmpl")
+	da, _ := ioutil.ReadFile(a)
+	db, _ := ioutil.ReadFile(b)
+	ioutil.WriteFile(a, db, 0644)
+	ioutil.WriteFile(b, da, 0644)
+	fsys, err := Open(os.DirFS(dir), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fsys.ReadFile("templates/a.tmpl"); err == nil {
+		t.Errorf("Swapped assets are accepted.")
+	}
+	if err = fsys.Check(); err == nil {
+		t.Errorf("Check accepts swapped assets.")
+	}
+
+	// So is a manifest edited to match.
+	m := &Manifest{}
+	mb, _ := ioutil.ReadFile(filepath.Join(dir, ManifestName))
+	json.Unmarshal(mb, m)
+	m.Files["templates/a.tmpl"], m.Files["templates/b/c.tmpl"] = m.Files["templates/b/c.tmpl"], m.Files["templates/a.tmpl"]
+	mb, _ = json.Marshal(m)
+	ioutil.WriteFile(filepath.Join(dir, ManifestName), mb, 0644)
+	if _, err = Open(os.DirFS(dir), c); err != ErrManifest {
+		t.Errorf("Expected ErrManifest for an edited manifest, got %v.", err)
+	}
+}
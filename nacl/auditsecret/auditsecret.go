@@ -0,0 +1,259 @@
+/*
+Package auditsecret writes encrypted, tamper-evident audit logs.
+
+A log is a text file with one record per line: an entry ("E ") or a
+checkpoint ("C "), followed by the base64 (standard encoding) ciphertext.
+Records are encrypted with padsecret or saltsecret and chained: the index of
+a record and the hash of the record before it are bound to it as associated
+data. The hash of a record is the SHA-256 digest of the previous hash and
+the record's line.
+
+Verify walks the chain and reports the first broken link, so entries that
+were deleted, reordered, modified or inserted are detected. Truncating the
+end of the log can not be detected from the log alone; keep the Head of the
+chain somewhere else to detect it.
+
+Checkpoints hold the index and the previous hash at their position in the
+chain, authenticated by the cipher. VerifyTail starts from the last
+checkpoint instead of the first record, so long logs can be checked
+quickly; it keeps the records after the last checkpoint in memory, so write
+checkpoints regularly (see Writer.CheckpointEvery).
+*/
+package auditsecret
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/andmarios/crypto/nacl/adsecret"
+)
+
+// Record kinds, written at the start of each line.
+const (
+	kindEntry      = "E"
+	kindCheckpoint = "C"
+)
+
+const checkpointSize = 8 + sha256.Size
+
+// maxLine limits the size of a record line.
+const maxLine = 16 << 20
+
+// Head is the position of a chain: the number of records and the hash of the
+// last record.
+type Head struct {
+	Index uint64
+	Hash  [sha256.Size]byte
+}
+
+// An Entry is a decrypted log entry.
+type Entry struct {
+	Index uint64
+	Data  []byte
+}
+
+// A ChainError reports the first broken link of a log.
+type ChainError struct {
+	Index  uint64
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return "audit log broken at record " + strconv.FormatUint(e.Index, 10) + ": " + e.Reason
+}
+
+// A Writer appends records to a log. CheckpointEvery, if not zero, writes a
+// checkpoint after that many entries.
+type Writer struct {
+	w               io.Writer
+	c               *adsecret.ADSecret
+	head            Head
+	entries         int
+	CheckpointEvery int
+}
+
+// NewWriter creates a Writer for a new log, written to w and encrypted with
+// c, usually a padsecret.PadSecret or saltsecret.SaltSecret.
+func NewWriter(w io.Writer, c adsecret.Cipher) *Writer {
+	return &Writer{w: w, c: adsecret.New(c)}
+}
+
+// Resume verifies the existing log r and returns a Writer that continues it
+// on w, usually the same file opened for appending.
+func Resume(r io.Reader, w io.Writer, c adsecret.Cipher) (*Writer, error) {
+	// Count the entries, so that checkpoints keep their positions.
+	entries := 0
+	head, err := Verify(r, c, func(Entry) error {
+		entries++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, c: adsecret.New(c), head: head, entries: entries}, nil
+}
+
+// Head returns the position of the chain after the last record written.
+func (w *Writer) Head() Head {
+	return w.head
+}
+
+// Append encrypts and writes an entry.
+func (w *Writer) Append(data []byte) error {
+	if err := w.write(kindEntry, data); err != nil {
+		return err
+	}
+	w.entries++
+	if w.CheckpointEvery > 0 && w.entries%w.CheckpointEvery == 0 {
+		return w.Checkpoint()
+	}
+	return nil
+}
+
+// Checkpoint writes a checkpoint.
+func (w *Writer) Checkpoint() error {
+	cp := binary.BigEndian.AppendUint64(nil, w.head.Index)
+	return w.write(kindCheckpoint, append(cp, w.head.Hash[:]...))
+}
+
+func (w *Writer) write(kind string, data []byte) error {
+	ct, err := w.c.Encrypt(data, linkAD(kind, w.head))
+	if err != nil {
+		return err
+	}
+	line := kind + " " + base64.StdEncoding.EncodeToString(ct)
+	if _, err = io.WriteString(w.w, line+"\n"); err != nil {
+		return err
+	}
+	w.head = next(w.head, line)
+	return nil
+}
+
+// linkAD is the associated data of the record at head. Checkpoints hold
+// their position in their plaintext instead, so that they can be decrypted
+// on their own.
+func linkAD(kind string, head Head) []byte {
+	if kind == kindCheckpoint {
+		return adsecret.Join(kind)
+	}
+	return adsecret.Join(kind, strconv.FormatUint(head.Index, 10), string(head.Hash[:]))
+}
+
+func next(head Head, line string) Head {
+	h := sha256.New()
+	h.Write(head.Hash[:])
+	h.Write([]byte(line))
+	n := Head{Index: head.Index + 1}
+	h.Sum(n.Hash[:0])
+	return n
+}
+
+// Verify walks the chain of the log r and calls f, if not nil, for every
+// entry. It returns the Head of the chain, or a *ChainError at the first
+// broken link. An error returned by f stops the walk and is returned as is.
+func Verify(r io.Reader, c adsecret.Cipher, f func(Entry) error) (Head, error) {
+	return verify(newScanner(r), adsecret.New(c), Head{}, f)
+}
+
+// VerifyTail is like Verify, but only verifies the records after the last
+// checkpoint of the log. Logs without checkpoints are verified whole.
+func VerifyTail(r io.Reader, c adsecret.Cipher, f func(Entry) error) (Head, error) {
+	// Only the lines from the last checkpoint on are kept.
+	var lines []string
+	s := newScanner(r)
+	last := -1
+	for n := 0; s.Scan(); n++ {
+		if len(s.Text()) > 0 && s.Text()[:1] == kindCheckpoint {
+			lines, last = lines[:0], n
+		}
+		lines = append(lines, s.Text())
+	}
+	if err := s.Err(); err != nil {
+		return Head{}, err
+	}
+	ad := adsecret.New(c)
+	if last < 0 {
+		return verify(&sliceScanner{lines: lines}, ad, Head{}, f)
+	}
+
+	// The checkpoint authenticates its own position in the chain.
+	_, ct, err := parseLine(lines[0])
+	if err != nil {
+		return Head{}, &ChainError{uint64(last), err.Error()}
+	}
+	pt, err := ad.Decrypt(ct, linkAD(kindCheckpoint, Head{}))
+	if err != nil || len(pt) != checkpointSize {
+		return Head{}, &ChainError{uint64(last), "checkpoint can not be decrypted"}
+	}
+	start := Head{Index: binary.BigEndian.Uint64(pt)}
+	copy(start.Hash[:], pt[8:])
+	return verify(&sliceScanner{lines: lines}, ad, start, f)
+}
+
+type lineScanner interface {
+	Scan() bool
+	Text() string
+	Err() error
+}
+
+type sliceScanner struct {
+	lines []string
+	i     int
+}
+
+func (s *sliceScanner) Scan() bool   { s.i++; return s.i <= len(s.lines) }
+func (s *sliceScanner) Text() string { return s.lines[s.i-1] }
+func (s *sliceScanner) Err() error   { return nil }
+
+func newScanner(r io.Reader) *bufio.Scanner {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, maxLine)
+	return s
+}
+
+func parseLine(line string) (kind string, ct []byte, err error) {
+	if len(line) < 2 || line[1] != ' ' || (line[:1] != kindEntry && line[:1] != kindCheckpoint) {
+		return "", nil, errors.New("malformed record")
+	}
+	ct, err = base64.StdEncoding.DecodeString(line[2:])
+	if err != nil {
+		return "", nil, errors.New("malformed record")
+	}
+	return line[:1], ct, nil
+}
+
+func verify(s lineScanner, ad *adsecret.ADSecret, head Head, f func(Entry) error) (Head, error) {
+	for s.Scan() {
+		line := s.Text()
+		kind, ct, err := parseLine(line)
+		if err != nil {
+			return head, &ChainError{head.Index, err.Error()}
+		}
+		pt, err := ad.Decrypt(ct, linkAD(kind, head))
+		if err == adsecret.ErrMismatch {
+			return head, &ChainError{head.Index, "record does not follow the previous one (deleted, reordered or inserted records)"}
+		}
+		if err != nil {
+			return head, &ChainError{head.Index, "record can not be decrypted: " + err.Error()}
+		}
+		if kind == kindCheckpoint {
+			if len(pt) != checkpointSize || binary.BigEndian.Uint64(pt) != head.Index || string(pt[8:]) != string(head.Hash[:]) {
+				return head, &ChainError{head.Index, "checkpoint does not match its position"}
+			}
+		} else if f != nil {
+			if err = f(Entry{head.Index, pt}); err != nil {
+				return head, err
+			}
+		}
+		head = next(head, line)
+	}
+	if err := s.Err(); err != nil {
+		return head, errors.New("reading audit log: " + err.Error())
+	}
+	return head, nil
+}
@@ -0,0 +1,134 @@
+package auditsecret
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andmarios/crypto/nacl/padsecret"
+)
+
+const pad = "qwertyuiopasdfghjklzxcvbnm123456"
+
+// newLog writes n entries with a checkpoint every 4 entries.
+func newLog(t *testing.T, n int) (string, Head) {
+	c, _ := padsecret.New("qwerty", pad, false)
+	var b bytes.Buffer
+	w := NewWriter(&b, c)
+	w.CheckpointEvery = 4
+	for i := 0; i < n; i++ {
+		if err := w.Append([]byte("event " + strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b.String(), w.Head()
+}
+
+func TestPackage(t *testing.T) {
+	c, _ := padsecret.New("qwerty", pad, false)
+	log, head := newLog(t, 10)
+	lines := strings.Split(strings.TrimSuffix(log, "\n"), "\n")
+	if len(lines) != 12 || lines[4][:2] != "C " || strings.Contains(log, "event") {
+		t.Errorf("Unexpected log:\n%s", log)
+	}
+
+	var entries []string
+	got, err := Verify(strings.NewReader(log), c, func(e Entry) error {
+		entries = append(entries, strconv.FormatUint(e.Index, 10)+":"+string(e.Data))
+		return nil
+	})
+	if err != nil || got != head {
+		t.Fatalf("Verify() returned %v, %v.", got, err)
+	}
+	if len(entries) != 10 || entries[0] != "0:event 0" || entries[4] != "5:event 4" {
+		t.Errorf("Verify() returned entries %v.", entries)
+	}
+
+	entries = nil
+	got, err = VerifyTail(strings.NewReader(log), c, func(e Entry) error {
+		entries = append(entries, string(e.Data))
+		return nil
+	})
+	if err != nil || got != head || len(entries) != 2 || entries[0] != "event 8" {
+		t.Errorf("VerifyTail() returned %v, %v, entries %v.", got, err, entries)
+	}
+
+	wrong, _ := padsecret.New("other", pad, false)
+	if _, err = Verify(strings.NewReader(log), wrong, nil); err == nil {
+		t.Errorf("Verify() succeeds with the wrong key.")
+	}
+
+	var b bytes.Buffer
+	b.WriteString(log)
+	w, err := Resume(strings.NewReader(log), &b, c)
+	if err != nil || w.Head() != head {
+		t.Fatalf("Resume() returned %v.", err)
+	}
+	w.Append([]byte("event 10"))
+	if got, err = Verify(&b, c, nil); err != nil || got.Index != 13 {
+		t.Errorf("Verify() of a resumed log returned %v, %v.", got, err)
+	}
+}
+
+// kinds returns the kinds of the records of a log.
+func kinds(log string) string {
+	var k []byte
+	for _, l := range strings.Split(strings.TrimSuffix(log, "\n"), "\n") {
+		k = append(k, l[0])
+	}
+	return string(k)
+}
+
+func TestResumeCheckpoints(t *testing.T) {
+	c, _ := padsecret.New("qwerty", pad, false)
+	log, _ := newLog(t, 10)
+	var b bytes.Buffer
+	b.WriteString(log)
+	w, err := Resume(strings.NewReader(log), &b, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.CheckpointEvery = 4
+	w.Append([]byte("event 10"))
+	w.Append([]byte("event 11"))
+
+	whole, _ := newLog(t, 12)
+	if kinds(b.String()) != kinds(whole) {
+		t.Errorf("Resumed log has records %s, expected %s.", kinds(b.String()), kinds(whole))
+	}
+}
+
+func TestTamper(t *testing.T) {
+	c, _ := padsecret.New("qwerty", pad, false)
+	log, _ := newLog(t, 10)
+	lines := strings.Split(strings.TrimSuffix(log, "\n"), "\n")
+
+	join := func(l ...string) string { return strings.Join(l, "\n") + "\n" }
+	copyLines := func() []string { return append([]string{}, lines...) }
+	other, _ := newLog(t, 3)
+
+	tests := []struct {
+		name  string
+		log   string
+		index uint64
+	}{
+		{"deleted entry", join(append(copyLines()[:2], lines[3:]...)...), 2},
+		{"reordered entries", join(append(append(copyLines()[:1], lines[2], lines[1]), lines[3:]...)...), 1},
+		{"modified entry", join(append(append(copyLines()[:6], "E "+lines[6][3:]), lines[7:]...)...), 6},
+		{"inserted entry", join(append(append(copyLines()[:2], strings.Split(other, "\n")[2]), lines[2:]...)...), 2},
+		{"moved checkpoint", join(append(append(copyLines()[:2], lines[4], lines[2], lines[3]), lines[5:]...)...), 2},
+		{"garbage", join(append(copyLines()[:7], "garbage")...), 7},
+	}
+	for _, tt := range tests {
+		_, err := Verify(strings.NewReader(tt.log), c, nil)
+		if e, ok := err.(*ChainError); !ok || e.Index != tt.index {
+			t.Errorf("Verify() of a log with a %s returned %v, expected a broken link at %d.", tt.name, err, tt.index)
+		}
+	}
+
+	tail := join(append(copyLines()[:11], lines[10])...)
+	if _, err := VerifyTail(strings.NewReader(tail), c, nil); err == nil {
+		t.Errorf("VerifyTail() does not detect a duplicated entry after the last checkpoint.")
+	}
+}
@@ -0,0 +1,613 @@
+/*
+Package backupsecret keeps encrypted, deduplicated backups in a local
+directory.
+
+Files are split into chunks of about 1MiB by a rolling hash, so that the
+chunk boundaries follow the content: a change in a large file only changes
+the chunks around it. Each chunk is identified by its HMAC-SHA256, encrypted
+with NaCl's secretbox and stored once, no matter how many files and
+snapshots hold it. A snapshot records the files of a backup (paths, modes,
+modification times and chunk lists) in an encrypted manifest.
+
+The keys are derived from the user key and the random salt of the repository
+with scrypt, like saltsecret does. Chunk IDs and the rolling hash are keyed,
+so the repository does not reveal whether it holds some known content. The
+number and sizes of chunks and snapshots are visible.
+
+A repository directory looks like:
+
+	config                  salt and key check
+	chunks/ab/abcdef...     encrypted chunks, by ID
+	snapshots/<id>          encrypted manifests
+
+Forget removes snapshots and Prune deletes the chunks no snapshot uses any
+more. Check verifies every snapshot and chunk. Only one process should
+write to a repository at a time.
+*/
+package backupsecret
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andmarios/crypto/nacl/internal/extract"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Header starts the config file of a repository. It is followed by the
+// scrypt N power (1 byte), the salt (32 bytes) and the sealed key check.
+const Header = "BACKUPSECRET1"
+
+// NPow is the N power of two iterations of scrypt, like saltsecret's.
+const NPow = 14
+
+// maxNPow limits the work a config file can ask for.
+const maxNPow = 20
+
+const (
+	keySize   = 32
+	saltSize  = 32
+	nonceSize = 24
+	keyCheck  = "backupsecret key check"
+)
+
+// Errors returned by repositories.
+var (
+	ErrKey      = errors.New("wrong key for backup repository")
+	ErrNotFound = errors.New("snapshot not found")
+	ErrCorrupt  = errors.New("backup repository data is corrupt")
+)
+
+// A Repo is an open backup repository.
+type Repo struct {
+	dir    string
+	encKey [keySize]byte
+	idKey  []byte
+	gear   [256]uint64
+}
+
+// A Snapshot is the manifest of a backup.
+type Snapshot struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Files  []File    `json:"files"`
+}
+
+// A File is a file, directory or symbolic link of a snapshot. Path is
+// relative to the source of the snapshot, with forward slashes.
+type File struct {
+	Path    string      `json:"path"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	Size    int64       `json:"size,omitempty"`
+	Link    string      `json:"link,omitempty"`
+	Chunks  []string    `json:"chunks,omitempty"`
+}
+
+// A CheckError lists the problems Check found.
+type CheckError struct {
+	Problems []string
+}
+
+func (e *CheckError) Error() string {
+	return "backup repository check failed: " + strings.Join(e.Problems, "; ")
+}
+
+// Init creates a new repository in dir, which must not hold one already, and
+// opens it.
+func Init(dir string, key []byte) (*Repo, error) {
+	if _, err := os.Stat(filepath.Join(dir, "config")); err == nil {
+		return nil, errors.New("backup repository already exists in " + dir)
+	}
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	r, err := newRepo(dir, key, salt, NPow)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range []string{"chunks", "snapshots"} {
+		if err = os.MkdirAll(filepath.Join(dir, d), 0700); err != nil {
+			return nil, err
+		}
+	}
+	check, err := r.seal([]byte(keyCheck))
+	if err != nil {
+		return nil, err
+	}
+	config := append([]byte(Header), NPow)
+	config = append(config, salt...)
+	config = append(config, check...)
+	if err = writeFile(filepath.Join(dir, "config"), config); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Open opens the repository in dir. It returns ErrKey if key is not the key
+// of the repository.
+func Open(dir string, key []byte) (*Repo, error) {
+	config, err := ioutil.ReadFile(filepath.Join(dir, "config"))
+	if err != nil {
+		return nil, err
+	}
+	if len(config) < len(Header)+1+saltSize || string(config[:len(Header)]) != Header {
+		return nil, errors.New("not a backupsecret repository: " + dir)
+	}
+	npow := uint(config[len(Header)])
+	if npow > maxNPow {
+		return nil, errors.New("backup repository asks for too many scrypt iterations")
+	}
+	salt := config[len(Header)+1 : len(Header)+1+saltSize]
+	r, err := newRepo(dir, key, salt, npow)
+	if err != nil {
+		return nil, err
+	}
+	if check, err := r.open(config[len(Header)+1+saltSize:]); err != nil || string(check) != keyCheck {
+		return nil, ErrKey
+	}
+	return r, nil
+}
+
+func newRepo(dir string, key, salt []byte, npow uint) (*Repo, error) {
+	k, err := scrypt.Key(key, salt, 2<<npow, 8, 1, 2*keySize)
+	if err != nil {
+		return nil, err
+	}
+	r := &Repo{dir: dir, idKey: k[keySize:]}
+	copy(r.encKey[:], k)
+	// The rolling hash is keyed too, so chunk sizes do not reveal content.
+	for i := 0; i < len(r.gear)/4; i++ {
+		m := hmac.New(sha256.New, r.idKey)
+		m.Write([]byte("gear"))
+		m.Write([]byte{byte(i)})
+		sum := m.Sum(nil)
+		for j := 0; j < 4; j++ {
+			r.gear[4*i+j] = binary.BigEndian.Uint64(sum[8*j:])
+		}
+	}
+	return r, nil
+}
+
+func (r *Repo) seal(data []byte) ([]byte, error) {
+	var nonce [nonceSize]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	return secretbox.Seal(nonce[:], data, &nonce, &r.encKey), nil
+}
+
+func (r *Repo) open(data []byte) ([]byte, error) {
+	if len(data) < nonceSize+secretbox.Overhead {
+		return nil, ErrCorrupt
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], data)
+	out, ok := secretbox.Open(nil, data[nonceSize:], &nonce, &r.encKey)
+	if !ok {
+		return nil, ErrCorrupt
+	}
+	return out, nil
+}
+
+// chunkID returns the keyed hash that identifies a chunk.
+func (r *Repo) chunkID(data []byte) string {
+	m := hmac.New(sha256.New, r.idKey)
+	m.Write(data)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func (r *Repo) chunkPath(id string) string {
+	return filepath.Join(r.dir, "chunks", id[:2], id)
+}
+
+// putChunk stores a chunk unless the repository has it already.
+func (r *Repo) putChunk(data []byte) (string, error) {
+	id := r.chunkID(data)
+	p := r.chunkPath(id)
+	if _, err := os.Stat(p); err == nil {
+		return id, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return "", err
+	}
+	sealed, err := r.seal(data)
+	if err != nil {
+		return "", err
+	}
+	return id, writeFile(p, sealed)
+}
+
+// getChunk reads a chunk and verifies it matches its ID.
+func (r *Repo) getChunk(id string) ([]byte, error) {
+	if len(id) != 2*sha256.Size {
+		return nil, ErrCorrupt
+	}
+	data, err := ioutil.ReadFile(r.chunkPath(id))
+	if err != nil {
+		return nil, err
+	}
+	data, err = r.open(data)
+	if err != nil || !hmac.Equal([]byte(r.chunkID(data)), []byte(id)) {
+		return nil, errors.New("chunk " + id + " is corrupt")
+	}
+	return data, nil
+}
+
+// writeFile writes a file atomically, through a temporary file.
+func writeFile(name string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Backup stores the file or directory tree at src and records it in a new
+// snapshot. Only the chunks the repository does not hold yet are written.
+func (r *Repo) Backup(src string) (*Snapshot, error) {
+	abs, err := filepath.Abs(src)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	suffix := make([]byte, 4)
+	if _, err = io.ReadFull(rand.Reader, suffix); err != nil {
+		return nil, err
+	}
+	s := &Snapshot{
+		ID:     now.Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix),
+		Time:   now,
+		Source: abs,
+	}
+	root, err := os.Stat(abs)
+	if err != nil {
+		return nil, err
+	}
+	base := abs
+	if !root.IsDir() {
+		base = filepath.Dir(abs)
+	}
+	err = filepath.WalkDir(abs, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f := File{Path: filepath.ToSlash(rel), Mode: fi.Mode(), ModTime: fi.ModTime()}
+		switch {
+		case fi.IsDir():
+		case fi.Mode().IsRegular():
+			// The size is what was read, in case the file changes
+			// during the backup.
+			if f.Chunks, f.Size, err = r.backupFile(p); err != nil {
+				return err
+			}
+		case fi.Mode()&fs.ModeSymlink != 0:
+			if f.Link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		s.Files = append(s.Files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = r.seal(data); err != nil {
+		return nil, err
+	}
+	if err = writeFile(filepath.Join(r.dir, "snapshots", s.ID), data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// backupFile stores the chunks of a file and returns their IDs and the
+// number of bytes read.
+func (r *Repo) backupFile(name string) ([]string, int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	var ids []string
+	var size int64
+	c := newChunker(f, &r.gear)
+	for {
+		chunk, err := c.next()
+		if err == io.EOF {
+			return ids, size, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		id, err := r.putChunk(chunk)
+		if err != nil {
+			return nil, 0, err
+		}
+		ids = append(ids, id)
+		size += int64(len(chunk))
+	}
+}
+
+// Snapshot reads the snapshot with id.
+func (r *Repo) Snapshot(id string) (*Snapshot, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return nil, ErrNotFound
+	}
+	data, err := ioutil.ReadFile(filepath.Join(r.dir, "snapshots", id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data, err = r.open(data); err != nil {
+		return nil, errors.New("snapshot " + id + " can not be decrypted")
+	}
+	s := &Snapshot{}
+	// The ID inside the manifest detects snapshots that were renamed.
+	if err = json.Unmarshal(data, s); err != nil || s.ID != id {
+		return nil, errors.New("snapshot " + id + " is corrupt")
+	}
+	return s, nil
+}
+
+// Snapshots returns the snapshots of the repository, oldest first.
+func (r *Repo) Snapshots() ([]*Snapshot, error) {
+	ids, err := r.snapshotIDs()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*Snapshot, 0, len(ids))
+	for _, id := range ids {
+		s, err := r.Snapshot(id)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return out, nil
+}
+
+func (r *Repo) snapshotIDs() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(r.dir, "snapshots"))
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			ids = append(ids, e.Name())
+		}
+	}
+	return ids, nil
+}
+
+// Restore restores the snapshot with id into dst. If paths are given, only
+// these paths, and everything under them if they are directories, are
+// restored. Every chunk is verified as it is read.
+func (r *Repo) Restore(id, dst string, paths ...string) error {
+	s, err := r.Snapshot(id)
+	if err != nil {
+		return err
+	}
+	var dirs []extract.Dir
+	found := make([]bool, len(paths))
+	for _, f := range s.Files {
+		if !extract.Selected(f.Path, paths, found) {
+			continue
+		}
+		target, err := extract.SafePath(dst, f.Path, "snapshot")
+		if err != nil {
+			return err
+		}
+		switch {
+		case f.Mode.IsDir():
+			if err = extract.Mkdir(target); err != nil {
+				return err
+			}
+			dirs = append(dirs, extract.Dir{Path: target, Mode: f.Mode, ModTime: f.ModTime})
+			continue
+		case f.Mode.IsRegular():
+			if err = r.restoreFile(target, f); err != nil {
+				return err
+			}
+		case f.Mode&fs.ModeSymlink != 0:
+			if err = os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			os.Remove(target)
+			if err = os.Symlink(f.Link, target); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = os.Chtimes(target, f.ModTime, f.ModTime); err != nil {
+			return err
+		}
+	}
+	if err = extract.SetDirs(dirs); err != nil {
+		return err
+	}
+	for i, ok := range found {
+		if !ok {
+			return errors.New("path " + paths[i] + " not found in snapshot " + id)
+		}
+	}
+	return nil
+}
+
+func (r *Repo) restoreFile(target string, f File) error {
+	out, err := extract.Create(target)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, id := range f.Chunks {
+		var data []byte
+		if data, err = r.getChunk(id); err != nil {
+			break
+		}
+		if _, err = out.Write(data); err != nil {
+			break
+		}
+		size += int64(len(data))
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && size != f.Size {
+		err = errors.New("restored " + f.Path + " does not match its size")
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chmod(target, f.Mode.Perm())
+}
+
+// Forget removes the snapshot with id. Its chunks stay until Prune.
+func (r *Repo) Forget(id string) error {
+	if _, err := r.Snapshot(id); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(r.dir, "snapshots", id))
+}
+
+// Prune deletes the chunks no snapshot uses and returns their number. It
+// refuses to prune if a snapshot can not be read, since its chunks would be
+// lost.
+func (r *Repo) Prune() (int, error) {
+	snapshots, err := r.Snapshots()
+	if err != nil {
+		return 0, err
+	}
+	used := make(map[string]bool)
+	for _, s := range snapshots {
+		for _, f := range s.Files {
+			for _, id := range f.Chunks {
+				used[id] = true
+			}
+		}
+	}
+	removed := 0
+	err = r.walkChunks(func(id, p string) error {
+		if used[id] {
+			return nil
+		}
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		if !strings.HasPrefix(id, ".tmp-") {
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// walkChunks calls f for every file in the chunks directory, including
+// temporary files left by interrupted backups.
+func (r *Repo) walkChunks(f func(id, path string) error) error {
+	return filepath.WalkDir(filepath.Join(r.dir, "chunks"), func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		return f(d.Name(), p)
+	})
+}
+
+// Check verifies that every snapshot can be read, that the chunks they use
+// exist, and that every chunk decrypts and matches its ID. It returns a
+// *CheckError listing the problems found.
+func (r *Repo) Check() error {
+	var problems []string
+	used := make(map[string]bool)
+	ids, err := r.snapshotIDs()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		s, err := r.Snapshot(id)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		for _, f := range s.Files {
+			for _, c := range f.Chunks {
+				used[c] = true
+			}
+		}
+	}
+	stored := make(map[string]bool)
+	err = r.walkChunks(func(id, p string) error {
+		if strings.HasPrefix(id, ".tmp-") {
+			return nil
+		}
+		stored[id] = true
+		if _, err := r.getChunk(id); err != nil {
+			problems = append(problems, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	missing := 0
+	for id := range used {
+		if !stored[id] {
+			missing++
+		}
+	}
+	if missing > 0 {
+		problems = append(problems, strconv.Itoa(missing)+" chunks used by snapshots are missing")
+	}
+	if len(problems) > 0 {
+		return &CheckError{problems}
+	}
+	return nil
+}
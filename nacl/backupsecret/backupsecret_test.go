@@ -0,0 +1,289 @@
+package backupsecret
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andmarios/crypto/nacl/internal/testtree"
+)
+
+var key = []byte("qwerty")
+
+// newTree creates a directory tree with a file of several chunks.
+func newTree(t *testing.T) (string, []byte) {
+	big := make([]byte, 8<<20)
+	rand.Read(big)
+	dir := testtree.New(t, map[string][]byte{
+		"notes.txt":              []byte("hello"),
+		"images/disk.img":        big,
+		"images/nested/empty.db": nil,
+	}, "notes.txt")
+	return dir, big
+}
+
+// countChunks returns the number of chunks stored in the repository.
+func countChunks(t *testing.T, dir string) int {
+	n := 0
+	filepath.Walk(filepath.Join(dir, "chunks"), func(p string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			n++
+		}
+		return err
+	})
+	return n
+}
+
+func TestBackupRestore(t *testing.T) {
+	src, big := newTree(t)
+	repoDir := t.TempDir()
+	r, err := Init(repoDir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := r.Backup(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countChunks(t, repoDir); n < 3 || n > 8<<20/MinChunk+1 {
+		t.Errorf("Backup stored %d chunks.", n)
+	}
+	for _, f := range []string{"config", "snapshots/" + s.ID} {
+		b, _ := ioutil.ReadFile(filepath.Join(repoDir, f))
+		if bytes.Contains(b, []byte("notes.txt")) || bytes.Contains(b, []byte("disk.img")) {
+			t.Errorf("%s contains file names in plaintext.", f)
+		}
+	}
+
+	r, err = Open(repoDir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := t.TempDir()
+	if err = r.Restore(s.ID, dst); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dst, "images/disk.img")); !bytes.Equal(b, big) {
+		t.Errorf("Restored disk.img does not match.")
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dst, "notes.txt")); string(b) != "hello" {
+		t.Errorf("Restored notes.txt is %q.", b)
+	}
+	if fi, err := os.Stat(filepath.Join(dst, "images/nested/empty.db")); err != nil || fi.Size() != 0 {
+		t.Errorf("Empty file was not restored: %v", err)
+	}
+	if fi, _ := os.Stat(filepath.Join(dst, "notes.txt")); fi.Mode().Perm() != 0600 {
+		t.Errorf("Restored notes.txt has mode %v.", fi.Mode())
+	}
+	mtime := testtree.ModTime
+	for _, p := range []string{"notes.txt", "images"} {
+		if fi, _ := os.Stat(filepath.Join(dst, p)); !fi.ModTime().Equal(mtime) {
+			t.Errorf("Restored %s has modification time %v.", p, fi.ModTime())
+		}
+	}
+	if link, _ := os.Readlink(filepath.Join(dst, "link")); link != "notes.txt" {
+		t.Errorf("Restored link points to %q.", link)
+	}
+
+	part := t.TempDir()
+	if err = r.Restore(s.ID, part, "images/nested"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(part, "notes.txt")); err == nil {
+		t.Errorf("Restore restored paths that were not asked for.")
+	}
+	if err = r.Restore(s.ID, part, "nope"); err == nil {
+		t.Errorf("Restore accepts paths missing from the snapshot.")
+	}
+	if err = r.Restore("nope", part); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v.", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Init(dir, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Init(dir, key); err == nil {
+		t.Errorf("Init overwrites an existing repository.")
+	}
+	if _, err := Open(dir, []byte("wrong")); err != ErrKey {
+		t.Errorf("Expected ErrKey, got %v.", err)
+	}
+	if _, err := Open(t.TempDir(), key); err == nil {
+		t.Errorf("Open accepts a directory without repository.")
+	}
+}
+
+func TestDeduplication(t *testing.T) {
+	src, big := newTree(t)
+	repoDir := t.TempDir()
+	r, _ := Init(repoDir, key)
+	s1, err := r.Backup(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n1 := countChunks(t, repoDir)
+	if _, err = r.Backup(src); err != nil {
+		t.Fatal(err)
+	}
+	if n := countChunks(t, repoDir); n != n1 {
+		t.Errorf("Unchanged backup stored %d new chunks.", n-n1)
+	}
+
+	// Insert bytes in the middle of the large file: only the chunks around
+	// the change are new.
+	changed := append(append(append([]byte{}, big[:3<<20]...), "inserted"...), big[3<<20:]...)
+	ioutil.WriteFile(filepath.Join(src, "images/disk.img"), changed, 0640)
+	s3, err := r.Backup(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countChunks(t, repoDir) - n1; n < 1 || n > 2 {
+		t.Errorf("Changed backup stored %d new chunks, expected 1 or 2.", n)
+	}
+
+	snapshots, err := r.Snapshots()
+	if err != nil || len(snapshots) != 3 || snapshots[0].ID != s1.ID || snapshots[2].ID != s3.ID {
+		t.Fatalf("Snapshots returned %v, %v.", snapshots, err)
+	}
+	for _, c := range []struct {
+		id   string
+		data []byte
+	}{{s1.ID, big}, {s3.ID, changed}} {
+		dst := t.TempDir()
+		if err = r.Restore(c.id, dst, "images/disk.img"); err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := ioutil.ReadFile(filepath.Join(dst, "images/disk.img")); !bytes.Equal(b, c.data) {
+			t.Errorf("Snapshot %s restores the wrong data.", c.id)
+		}
+	}
+}
+
+func TestForgetPrune(t *testing.T) {
+	src, _ := newTree(t)
+	repoDir := t.TempDir()
+	r, _ := Init(repoDir, key)
+	s1, _ := r.Backup(src)
+	n1 := countChunks(t, repoDir)
+	big := make([]byte, 2<<20)
+	rand.Read(big)
+	ioutil.WriteFile(filepath.Join(src, "images/disk.img"), big, 0640)
+	s2, _ := r.Backup(src)
+
+	if n, err := r.Prune(); err != nil || n != 0 {
+		t.Errorf("Prune removed %d chunks used by snapshots: %v", n, err)
+	}
+	if err := r.Forget(s1.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Forget(s1.ID); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v.", err)
+	}
+	n, err := r.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The chunk of notes.txt is still used.
+	if n != n1-1 {
+		t.Errorf("Prune removed %d chunks, expected %d.", n, n1-1)
+	}
+	if err = r.Check(); err != nil {
+		t.Error(err)
+	}
+	dst := t.TempDir()
+	if err = r.Restore(s2.ID, dst); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dst, "images/disk.img")); !bytes.Equal(b, big) {
+		t.Errorf("Snapshot restores the wrong data after Prune.")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	src, _ := newTree(t)
+	repoDir := t.TempDir()
+	r, _ := Init(repoDir, key)
+	s, _ := r.Backup(src)
+	if err := r.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	var chunks []string
+	filepath.Walk(filepath.Join(repoDir, "chunks"), func(p string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			chunks = append(chunks, p)
+		}
+		return err
+	})
+	b, _ := ioutil.ReadFile(chunks[0])
+	b[len(b)-1] ^= 1
+	ioutil.WriteFile(chunks[0], b, 0600)
+	os.Remove(chunks[1])
+	err := r.Check()
+	if e, ok := err.(*CheckError); !ok || len(e.Problems) != 2 {
+		t.Errorf("Expected a corrupt and a missing chunk, got %v.", err)
+	}
+	if err = r.Restore(s.ID, t.TempDir()); err == nil {
+		t.Errorf("Restore accepts corrupt chunks.")
+	}
+
+	// A snapshot renamed over another is detected.
+	snap := filepath.Join(repoDir, "snapshots", s.ID)
+	os.Rename(snap, filepath.Join(repoDir, "snapshots", "20000101T000000Z-00000000"))
+	if _, err = r.Snapshot("20000101T000000Z-00000000"); err == nil {
+		t.Errorf("Snapshot accepts a renamed snapshot.")
+	}
+}
+
+func TestChunker(t *testing.T) {
+	var gear [256]uint64
+	for i := range gear {
+		b := make([]byte, 8)
+		rand.Read(b)
+		gear[i] = binary.BigEndian.Uint64(b)
+	}
+	data := make([]byte, 16<<20)
+	rand.Read(data)
+	chunks := func(data []byte) map[string]bool {
+		m := make(map[string]bool)
+		c := newChunker(bytes.NewReader(data), &gear)
+		total := 0
+		for {
+			b, err := c.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(b) > MaxChunk || (len(b) < MinChunk && total+len(b) != len(data)) {
+				t.Errorf("Chunk of %d bytes.", len(b))
+			}
+			total += len(b)
+			m[string(b)] = true
+		}
+		if total != len(data) {
+			t.Errorf("Chunks hold %d bytes, expected %d.", total, len(data))
+		}
+		return m
+	}
+	a := chunks(data)
+	b := chunks(append([]byte("shifted"), data...))
+	shared := 0
+	for c := range b {
+		if a[c] {
+			shared++
+		}
+	}
+	if shared < len(a)-2 {
+		t.Errorf("Only %d of %d chunks survive an insertion.", shared, len(a))
+	}
+}
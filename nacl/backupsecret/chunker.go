@@ -0,0 +1,72 @@
+package backupsecret
+
+import (
+	"io"
+)
+
+// Chunk sizes. Boundaries are placed by a rolling hash, so chunks average
+// about MinChunk + 1MiB.
+const (
+	MinChunk = 256 << 10
+	MaxChunk = 4 << 20
+)
+
+const (
+	maskBits = 20
+	// window is the number of bytes the rolling hash depends on.
+	window = 64
+)
+
+// A chunker splits a stream into content-defined chunks with a gear hash:
+// an insertion or deletion only changes the chunks around it, so unchanged
+// parts of a file are stored once.
+type chunker struct {
+	r    io.Reader
+	gear *[256]uint64
+	buf  []byte
+	out  []byte
+	n    int
+	eof  bool
+}
+
+func newChunker(r io.Reader, gear *[256]uint64) *chunker {
+	return &chunker{r: r, gear: gear, buf: make([]byte, MaxChunk)}
+}
+
+// next returns the next chunk, valid until the following call, or io.EOF.
+func (c *chunker) next() ([]byte, error) {
+	if !c.eof && c.n < MaxChunk {
+		m, err := io.ReadFull(c.r, c.buf[c.n:])
+		c.n += m
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			c.eof = true
+		default:
+			return nil, err
+		}
+	}
+	if c.n == 0 {
+		return nil, io.EOF
+	}
+	cut := c.boundary(c.buf[:c.n])
+	c.out = append(c.out[:0], c.buf[:cut]...)
+	c.n = copy(c.buf, c.buf[cut:c.n])
+	return c.out, nil
+}
+
+// boundary returns the length of the chunk at the start of b.
+func (c *chunker) boundary(b []byte) int {
+	if len(b) <= MinChunk {
+		return len(b)
+	}
+	const mask = (1<<maskBits - 1) << (64 - maskBits)
+	var h uint64
+	for i := MinChunk - window; i < len(b); i++ {
+		h = h<<1 + c.gear[b[i]]
+		if i >= MinChunk && h&mask == 0 {
+			return i + 1
+		}
+	}
+	return len(b)
+}
@@ -0,0 +1,131 @@
+/*
+Package blindindex computes blind indexes for values encrypted with padsecret
+or saltsecret.
+
+Encrypt uses a random nonce, so two encryptions of the same value differ and an
+encrypted column can not be searched. A blind index is a keyed BLAKE2b digest
+of the value, stored in a column next to the ciphertext. Searching for a value
+means computing its blind index and looking it up:
+
+	SELECT ... WHERE email_idx = ?
+
+Every index name (usually one per column) gets its own subkey, derived from the
+root key, so indexes of different columns can not be correlated. Digests are
+truncated to a configurable size: shorter indexes leak less about the values,
+since more of them collide, but return more false positives that have to be
+filtered out after decrypting.
+
+Values are usually normalized (trimmed, lowercased) before indexing, so that
+lookups match regardless of how the value was typed. Compound indexes cover
+several values at once, for example a first and last name.
+*/
+package blindindex
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"unicode"
+
+	"github.com/andmarios/crypto/nacl/adsecret"
+	"github.com/andmarios/crypto/nacl/padsecret"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Limits of the index size, in bytes.
+const (
+	MinSize = 4
+	MaxSize = 32
+)
+
+// A BlindIndex holds the root key and the size of the indexes.
+type BlindIndex struct {
+	key  []byte
+	size int
+}
+
+// New creates a new BlindIndex instance. key and pad are combined into a root
+// key like padsecret.New does; use a different key than the one that encrypts
+// the values. size is the length of the indexes in bytes.
+func New(key, pad string, size int) (*BlindIndex, error) {
+	k, err := padsecret.Key(key, pad)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromKey(k, size)
+}
+
+// NewFromKey creates a new BlindIndex instance from a raw 32 bytes root key.
+// size is the length of the indexes in bytes.
+func NewFromKey(key *[32]byte, size int) (*BlindIndex, error) {
+	if size < MinSize || size > MaxSize {
+		return nil, errors.New("blind index size should be between 4 and 32 bytes")
+	}
+	k := make([]byte, len(key))
+	copy(k, key[:])
+	return &BlindIndex{k, size}, nil
+}
+
+// subkey derives the key of the index name.
+func (b BlindIndex) subkey(name string) []byte {
+	h, _ := blake2b.New256(b.key)
+	h.Write(adsecret.Join("blindindex", name))
+	return h.Sum(nil)
+}
+
+// Index returns the blind index of value for the index name.
+func (b BlindIndex) Index(name string, value []byte) []byte {
+	h, _ := blake2b.New256(b.subkey(name))
+	h.Write(value)
+	return h.Sum(nil)[:b.size]
+}
+
+// IndexString returns the blind index of value for the index name, encoded
+// as unpadded URL-safe base64, for text columns.
+func (b BlindIndex) IndexString(name, value string) string {
+	return base64.RawURLEncoding.EncodeToString(b.Index(name, []byte(value)))
+}
+
+// Normalized returns the blind index of value after applying normalizers
+// to it, in order.
+func (b BlindIndex) Normalized(name, value string, normalizers ...Normalizer) []byte {
+	return b.Index(name, []byte(Normalize(value, normalizers...)))
+}
+
+// Compound returns the blind index of several values together. The values are
+// length prefixed, so ("ab", "c") and ("a", "bc") have different indexes.
+func (b BlindIndex) Compound(name string, values ...string) []byte {
+	return b.Index(name, adsecret.Join(values...))
+}
+
+// A Normalizer transforms a value before it is indexed.
+type Normalizer func(string) string
+
+// Normalize applies normalizers to value, in order.
+func Normalize(value string, normalizers ...Normalizer) string {
+	for _, n := range normalizers {
+		value = n(value)
+	}
+	return value
+}
+
+// Common normalizers.
+var (
+	// Lower maps the value to lower case.
+	Lower Normalizer = strings.ToLower
+	// TrimSpace removes leading and trailing white space.
+	TrimSpace Normalizer = strings.TrimSpace
+	// CollapseSpace replaces runs of white space with a single space and trims the value.
+	CollapseSpace Normalizer = func(s string) string {
+		return strings.Join(strings.Fields(s), " ")
+	}
+	// Digits removes everything but digits, for phone or card numbers.
+	Digits Normalizer = func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, s)
+	}
+)
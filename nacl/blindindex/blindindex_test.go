@@ -0,0 +1,77 @@
+package blindindex
+
+import (
+	"bytes"
+	"testing"
+)
+
+const pad = "qwertyuiopasdfghjklzxcvbnm123456"
+
+func TestPackage(t *testing.T) {
+	if _, err := New("index key", pad, 2); err == nil {
+		t.Errorf("New() accepts too small index size.")
+	}
+	if _, err := New("index key", "short", 8); err == nil {
+		t.Errorf("New() accepts pad smaller than 32 bytes.")
+	}
+
+	b, err := New("index key", pad, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx := b.Index("email", []byte("alice@example.com"))
+	if len(idx) != 8 {
+		t.Errorf("Index length is %d, expected 8.", len(idx))
+	}
+	if !bytes.Equal(idx, b.Index("email", []byte("alice@example.com"))) {
+		t.Errorf("Index() is not deterministic.")
+	}
+	if bytes.Equal(idx, b.Index("email", []byte("bob@example.com"))) {
+		t.Errorf("Different values have the same index.")
+	}
+	if bytes.Equal(idx, b.Index("backup_email", []byte("alice@example.com"))) {
+		t.Errorf("Different index names share a subkey.")
+	}
+
+	other, _ := New("other key", pad, 8)
+	if bytes.Equal(idx, other.Index("email", []byte("alice@example.com"))) {
+		t.Errorf("Different keys produce the same index.")
+	}
+
+	long, _ := New("index key", pad, 16)
+	if !bytes.Equal(idx, long.Index("email", []byte("alice@example.com"))[:8]) {
+		t.Errorf("Index is not a truncation of the full digest.")
+	}
+
+	if s := b.IndexString("email", "alice@example.com"); len(s) != 11 {
+		t.Errorf("IndexString() returned '%s'.", s)
+	}
+}
+
+func TestNormalized(t *testing.T) {
+	b, _ := New("index key", pad, 8)
+	a := b.Normalized("email", "  Alice@Example.COM ", TrimSpace, Lower)
+	if !bytes.Equal(a, b.Index("email", []byte("alice@example.com"))) {
+		t.Errorf("Normalized() does not normalize.")
+	}
+
+	phone := b.Normalized("phone", "+30 (210) 123-4567", Digits)
+	if !bytes.Equal(phone, b.Normalized("phone", "302101234567", Digits)) {
+		t.Errorf("Digits does not normalize phone numbers.")
+	}
+
+	if s := Normalize("  John \t  Smith ", CollapseSpace, Lower); s != "john smith" {
+		t.Errorf("Normalize() returned '%s'.", s)
+	}
+}
+
+func TestCompound(t *testing.T) {
+	b, _ := New("index key", pad, 8)
+	if bytes.Equal(b.Compound("name", "ab", "c"), b.Compound("name", "a", "bc")) {
+		t.Errorf("Compound() is ambiguous.")
+	}
+	if !bytes.Equal(b.Compound("name", "John", "Smith"), b.Compound("name", "John", "Smith")) {
+		t.Errorf("Compound() is not deterministic.")
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Package cookiesecret implements an encrypted cookie (session) codec on top of
+padsecret or saltsecret.
+
+A cookie value is sealed together with its creation time and its max-age.
+The cookie name is bound to the value as associated data (see adsecret), so a
+value can not be moved to another cookie. The output is URL-safe base64 and
+is checked against the 4096 bytes limit browsers impose on cookies. Values are
+compressed (zlib) when this makes them shorter.
+
+Keys may be rotated: a CookieSecret encrypts with its current cipher and
+decrypts with the current or any of the previous ciphers.
+
+Padsecret is the better fit for cookies, since every request needs a decryption
+and saltsecret's key derivation is slow on purpose.
+*/
+package cookiesecret
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/andmarios/crypto/nacl/adsecret"
+)
+
+// MaxCookieSize is the maximum size of a cookie (name and value) that
+// browsers are required to support.
+const MaxCookieSize = 4096
+
+// DefaultMaxAge is the max-age of cookies created by New.
+const DefaultMaxAge = 24 * time.Hour
+
+// MaxMaxAge is the longest max-age a cookie can hold.
+const MaxMaxAge = math.MaxUint32 * time.Second
+
+// Errors returned by Decode.
+var (
+	ErrInvalid  = errors.New("cookie value is invalid or was tampered with")
+	ErrExpired  = errors.New("cookie has expired")
+	ErrTooLarge = errors.New("encoded cookie exceeds the cookie size limit")
+)
+
+const (
+	headerSize = 13 // creation time (8), max-age (4), flags (1)
+
+	flagCompressed byte = 0x01
+)
+
+// A CookieSecret encodes and decodes encrypted cookie values.
+// MaxAge is the max-age sealed in new cookies. A zero or negative MaxAge
+// means the cookies never expire; NewCookie makes them session cookies.
+// MaxAge may not exceed MaxMaxAge, and is rounded up to whole seconds. Now is
+// the clock used to seal and check cookies; it defaults to time.Now and may
+// be replaced, for example in tests.
+type CookieSecret struct {
+	ciphers []*adsecret.ADSecret
+	MaxAge  time.Duration
+	Now     func() time.Time
+}
+
+// New creates a new CookieSecret instance. c is used to encrypt and decrypt
+// cookies, previous are older ciphers that are tried, in order, when c can not
+// decrypt a cookie.
+func New(c adsecret.Cipher, previous ...adsecret.Cipher) *CookieSecret {
+	ciphers := []*adsecret.ADSecret{adsecret.New(c)}
+	for _, p := range previous {
+		ciphers = append(ciphers, adsecret.New(p))
+	}
+	return &CookieSecret{ciphers, DefaultMaxAge, time.Now}
+}
+
+// Encode seals value for the cookie name and returns the encoded cookie value.
+func (c CookieSecret) Encode(name string, value []byte) (string, error) {
+	flags := byte(0)
+	if z := compress(value); len(z) < len(value) {
+		value = z
+		flags |= flagCompressed
+	}
+
+	if c.MaxAge > MaxMaxAge {
+		return "", errors.New("cookie max-age is longer than MaxMaxAge")
+	}
+	maxAge := c.maxAgeSeconds()
+	msg := make([]byte, headerSize, headerSize+len(value))
+	binary.BigEndian.PutUint64(msg, uint64(c.Now().Unix()))
+	binary.BigEndian.PutUint32(msg[8:], uint32(maxAge))
+	msg[12] = flags
+	msg = append(msg, value...)
+
+	enc, err := c.ciphers[0].Encrypt(msg, []byte(name))
+	if err != nil {
+		return "", err
+	}
+	out := base64.RawURLEncoding.EncodeToString(enc)
+	if len(name)+1+len(out) > MaxCookieSize {
+		return "", ErrTooLarge
+	}
+	return out, nil
+}
+
+// maxAgeSeconds returns MaxAge in seconds, rounded up so that a short MaxAge
+// does not become 0, which never expires. A negative MaxAge is 0.
+func (c CookieSecret) maxAgeSeconds() int64 {
+	if c.MaxAge <= 0 {
+		return 0
+	}
+	return int64((c.MaxAge + time.Second - 1) / time.Second)
+}
+
+// Decode verifies and decrypts the encoded value of the cookie name. It returns
+// ErrExpired if the cookie's max-age has passed and ErrInvalid if the value was
+// not created for this cookie name with any of the instance's keys.
+func (c CookieSecret) Decode(name, value string) ([]byte, error) {
+	if len(name)+1+len(value) > MaxCookieSize {
+		return nil, ErrTooLarge
+	}
+	enc, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	var msg []byte
+	for _, cipher := range c.ciphers {
+		msg, err = cipher.Decrypt(enc, []byte(name))
+		if err == nil {
+			break
+		}
+	}
+	if err != nil || len(msg) < headerSize {
+		return nil, ErrInvalid
+	}
+
+	created := time.Unix(int64(binary.BigEndian.Uint64(msg)), 0)
+	maxAge := time.Duration(binary.BigEndian.Uint32(msg[8:])) * time.Second
+	if maxAge > 0 && !c.Now().Before(created.Add(maxAge)) {
+		return nil, ErrExpired
+	}
+
+	out := msg[headerSize:]
+	if msg[12]&flagCompressed == flagCompressed {
+		r, err := zlib.NewReader(bytes.NewReader(out))
+		if err != nil {
+			return nil, ErrInvalid
+		}
+		defer r.Close()
+		out, err = io.ReadAll(r)
+		if err != nil {
+			return nil, ErrInvalid
+		}
+	}
+	return out, nil
+}
+
+// NewCookie returns an HttpOnly, Secure cookie named name with an encoded value
+// and a MaxAge that matches the instance's MaxAge. Cookies that never expire
+// are session cookies (MaxAge 0), since a negative MaxAge deletes a cookie.
+func (c CookieSecret) NewCookie(name string, value []byte) (*http.Cookie, error) {
+	enc, err := c.Encode(name, value)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Cookie{
+		Name:     name,
+		Value:    enc,
+		Path:     "/",
+		MaxAge:   int(c.maxAgeSeconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}, nil
+}
+
+// Value decodes the value of the cookie name from r.
+func (c CookieSecret) Value(r *http.Request, name string) ([]byte, error) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.Decode(name, cookie.Value)
+}
+
+func compress(msg []byte) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write(msg)
+	w.Close()
+	return b.Bytes()
+}
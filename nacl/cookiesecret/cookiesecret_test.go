@@ -0,0 +1,160 @@
+package cookiesecret
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andmarios/crypto/nacl/padsecret"
+)
+
+const pad = "qwertyuiopasdfghjklzxcvbnm123456"
+
+func TestPackage(t *testing.T) {
+	pc, _ := padsecret.New("qwerty", pad, false)
+	c := New(pc)
+	msg := []byte(`{"user":42,"role":"admin"}`)
+
+	enc, err := c.Encode("session", msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.ContainsAny(enc, "+/=;, ") {
+		t.Errorf("Encoded value '%s' is not URL and cookie safe.", enc)
+	}
+
+	dec, err := c.Decode("session", enc)
+	if err != nil {
+		t.Errorf("Decode() failed: %v", err)
+	}
+	if !bytes.Equal(dec, msg) {
+		t.Errorf("Decoded value '%s' differs from encoded value '%s'.", dec, msg)
+	}
+
+	if _, err = c.Decode("other", enc); err != ErrInvalid {
+		t.Errorf("Decode() with another cookie name returned %v, expected ErrInvalid.", err)
+	}
+
+	tampered := []byte(enc)
+	tampered[len(tampered)/2] ^= 0x01
+	if _, err = c.Decode("session", string(tampered)); err != ErrInvalid {
+		t.Errorf("Decode() of tampered value returned %v, expected ErrInvalid.", err)
+	}
+
+	if _, err = c.Decode("session", "not base64!"); err != ErrInvalid {
+		t.Errorf("Decode() of garbage returned %v, expected ErrInvalid.", err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	pc, _ := padsecret.New("qwerty", pad, false)
+	c := New(pc)
+	c.MaxAge = time.Hour
+	c.Now = func() time.Time { return now }
+
+	enc, err := c.Encode("session", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(59 * time.Minute)
+	if _, err = c.Decode("session", enc); err != nil {
+		t.Errorf("Decode() of fresh cookie failed: %v", err)
+	}
+	now = now.Add(time.Minute)
+	if _, err = c.Decode("session", enc); err != ErrExpired {
+		t.Errorf("Decode() of expired cookie returned %v, expected ErrExpired.", err)
+	}
+
+	c.MaxAge = 0
+	enc, _ = c.Encode("session", []byte("hello"))
+	now = now.Add(10000 * time.Hour)
+	if _, err = c.Decode("session", enc); err != nil {
+		t.Errorf("Decode() of cookie without max-age failed: %v", err)
+	}
+
+	c.MaxAge = MaxMaxAge + time.Second
+	if _, err = c.Encode("session", []byte("hello")); err == nil {
+		t.Errorf("Encode() accepts a max-age that does not fit.")
+	}
+	c.MaxAge = -time.Hour
+	if cookie, err := c.NewCookie("session", []byte("hello")); err != nil || cookie.MaxAge != 0 {
+		t.Errorf("NewCookie() without max-age returned MaxAge %d, %v, expected a session cookie.", cookie.MaxAge, err)
+	}
+
+	// A max-age under a second is rounded up, not down to never expiring.
+	c.MaxAge = 500 * time.Millisecond
+	enc, _ = c.Encode("session", []byte("hello"))
+	now = now.Add(time.Second)
+	if _, err = c.Decode("session", enc); err != ErrExpired {
+		t.Errorf("Decode() of cookie with max-age 500ms returned %v, expected ErrExpired.", err)
+	}
+	if cookie, err := c.NewCookie("session", []byte("hello")); err != nil || cookie.MaxAge != 1 {
+		t.Errorf("NewCookie() with max-age 500ms returned MaxAge %d, %v.", cookie.MaxAge, err)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	oc, _ := padsecret.New("old", pad, false)
+	nc, _ := padsecret.New("new", pad, false)
+	older, _ := padsecret.New("older", pad, false)
+	old := New(oc)
+	enc, _ := old.Encode("session", []byte("hello"))
+
+	c := New(nc, older, oc)
+	dec, err := c.Decode("session", enc)
+	if err != nil || string(dec) != "hello" {
+		t.Errorf("Decode() with previous key failed: %v", err)
+	}
+
+	enc, _ = c.Encode("session", []byte("hello"))
+	if _, err = old.Decode("session", enc); err == nil {
+		t.Errorf("Encode() does not use the current key.")
+	}
+}
+
+func TestSize(t *testing.T) {
+	pc, _ := padsecret.New("qwerty", pad, false)
+	c := New(pc)
+
+	// Compressible values fit even if they are larger than the limit.
+	big := bytes.Repeat([]byte("abcdefgh"), 1024)
+	enc, err := c.Encode("session", big)
+	if err != nil {
+		t.Fatalf("Encode() of compressible value failed: %v", err)
+	}
+	dec, err := c.Decode("session", enc)
+	if err != nil || !bytes.Equal(dec, big) {
+		t.Errorf("Decode() of compressed value failed: %v", err)
+	}
+
+	random := make([]byte, 4096)
+	_, _ = io.ReadFull(rand.Reader, random)
+	if _, err = c.Encode("session", random); err != ErrTooLarge {
+		t.Errorf("Encode() of incompressible large value returned %v, expected ErrTooLarge.", err)
+	}
+}
+
+func TestCookie(t *testing.T) {
+	pc, _ := padsecret.New("qwerty", pad, false)
+	c := New(pc)
+	cookie, err := c.NewCookie("session", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cookie.HttpOnly || !cookie.Secure || cookie.MaxAge != int(DefaultMaxAge/time.Second) {
+		t.Errorf("NewCookie() returned unexpected cookie attributes: %v", cookie)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	dec, err := c.Value(r, "session")
+	if err != nil || string(dec) != "hello" {
+		t.Errorf("Value() failed: %v", err)
+	}
+}
@@ -0,0 +1,401 @@
+/*
+Package docsecret encrypts the values of JSON and YAML documents with
+padsecret or saltsecret, leaving keys and structure readable, like sops does.
+
+Every leaf value (string, number, boolean or null) is replaced by a string of
+the form
+
+	ENC[nacl,data:<base64 ciphertext>]
+
+The ciphertext holds the type and the text of the value, and is bound to the
+path of the value as associated data (through adsecret), so encrypted values
+can not be moved to another key. A MAC over all the values of the document,
+with their paths, and over the kind and length of every mapping and sequence,
+is encrypted and stored under the top level key "docsecret":
+
+	"docsecret": {"mac": "ENC[nacl,data:...]", "version": "1"}
+
+Decryption restores the original values and verifies the MAC, so values that
+were added, removed or reordered after encryption are detected, and so are
+empty mappings and sequences. Values that
+are not encrypted are left as they are, but are still covered by the MAC.
+
+The top level of a document has to be a mapping. Key order is preserved.
+Documents are written back indented with two spaces; the original formatting
+and, for YAML, comments are not kept.
+
+YAML support is limited to a simple subset: block mappings with unique keys,
+block sequences, plain, single and double quoted scalars on a single line,
+and empty flow collections ([] and {}). Double quoted strings take the
+escapes of JSON. Anything else is rejected rather than guessed at:
+anchors, aliases, tags, block scalars, other flow collections, complex and
+empty keys, directives, multiple documents and collections that start on
+the line of a key, like "a: b: c".
+*/
+package docsecret
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/andmarios/crypto/nacl/adsecret"
+)
+
+// MetadataKey is the top level key holding the document MAC.
+const MetadataKey = "docsecret"
+
+const (
+	version   = "1"
+	encPrefix = "ENC[nacl,data:"
+	encSuffix = "]"
+)
+
+// ErrMAC is returned by Decrypt when the values of a document do not match
+// its MAC.
+var ErrMAC = errors.New("document MAC does not match")
+
+// A DocSecret holds the cipher used for the values.
+type DocSecret struct {
+	ad *adsecret.ADSecret
+}
+
+// New creates a new DocSecret instance that encrypts with c, usually a
+// padsecret.PadSecret or saltsecret.SaltSecret. Note that saltsecret derives
+// a key for every value, which is slow for large documents.
+func New(c adsecret.Cipher) *DocSecret {
+	return &DocSecret{adsecret.New(c)}
+}
+
+// EncryptJSON encrypts the values of a JSON document.
+func (d DocSecret) EncryptJSON(doc []byte) ([]byte, error) {
+	root, err := parseJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	if err = d.encrypt(root); err != nil {
+		return nil, err
+	}
+	return formatJSON(root), nil
+}
+
+// DecryptJSON decrypts a JSON document encrypted by EncryptJSON.
+func (d DocSecret) DecryptJSON(doc []byte) ([]byte, error) {
+	root, err := parseJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	if err = d.decrypt(root); err != nil {
+		return nil, err
+	}
+	return formatJSON(root), nil
+}
+
+// EncryptYAML encrypts the values of a YAML document.
+func (d DocSecret) EncryptYAML(doc []byte) ([]byte, error) {
+	root, err := parseYAML(doc)
+	if err != nil {
+		return nil, err
+	}
+	if err = d.encrypt(root); err != nil {
+		return nil, err
+	}
+	return formatYAML(root), nil
+}
+
+// DecryptYAML decrypts a YAML document encrypted by EncryptYAML.
+func (d DocSecret) DecryptYAML(doc []byte) ([]byte, error) {
+	root, err := parseYAML(doc)
+	if err != nil {
+		return nil, err
+	}
+	if err = d.decrypt(root); err != nil {
+		return nil, err
+	}
+	return formatYAML(root), nil
+}
+
+// Node kinds.
+const (
+	scalar = iota
+	mapping
+	sequence
+)
+
+// Scalar types. They are stored as the first byte of the encrypted values.
+const (
+	typeStr  byte = 's'
+	typeNum  byte = 'n'
+	typeBool byte = 'b'
+	typeNull byte = 'z'
+)
+
+// A node is a parsed document. Strings hold their decoded value, the other
+// scalars their text as written in the document.
+type node struct {
+	kind  int
+	keys  []string
+	items []*node
+	typ   byte
+	value string
+}
+
+func (d DocSecret) encrypt(root *node) error {
+	if root.kind != mapping {
+		return errors.New("docsecret document should be a mapping")
+	}
+	for _, k := range root.keys {
+		if k == MetadataKey {
+			return errors.New("document is already encrypted")
+		}
+	}
+
+	mac := sha256.New()
+	err := walk(root, nil, func(n *node, path []string) error {
+		mac.Write(macEntry(path, n))
+		if n.kind != scalar {
+			return nil
+		}
+		ct, err := d.ad.Encrypt(append([]byte{n.typ}, n.value...), adsecret.Join(path...))
+		if err != nil {
+			return err
+		}
+		n.typ, n.value = typeStr, encode(ct)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ct, err := d.ad.Encrypt(mac.Sum(nil), adsecret.Join(MetadataKey, "mac"))
+	if err != nil {
+		return err
+	}
+	root.keys = append(root.keys, MetadataKey)
+	root.items = append(root.items, &node{
+		kind: mapping,
+		keys: []string{"mac", "version"},
+		items: []*node{
+			{typ: typeStr, value: encode(ct)},
+			{typ: typeStr, value: version},
+		},
+	})
+	return nil
+}
+
+func (d DocSecret) decrypt(root *node) error {
+	if root.kind != mapping {
+		return errors.New("docsecret document should be a mapping")
+	}
+	var meta *node
+	for i, k := range root.keys {
+		if k == MetadataKey {
+			meta = root.items[i]
+			root.keys = append(root.keys[:i], root.keys[i+1:]...)
+			root.items = append(root.items[:i], root.items[i+1:]...)
+			break
+		}
+	}
+	if meta == nil {
+		return errors.New("document is not encrypted")
+	}
+	var macValue, ver string
+	if meta.kind == mapping {
+		for i, k := range meta.keys {
+			switch k {
+			case "mac":
+				macValue = meta.items[i].value
+			case "version":
+				ver = meta.items[i].value
+			}
+		}
+	}
+	if ver != version {
+		return errors.New("unsupported docsecret version '" + ver + "'")
+	}
+	ct, ok := decode(macValue)
+	if !ok {
+		return errors.New("document MAC is malformed")
+	}
+	expected, err := d.ad.Decrypt(ct, adsecret.Join(MetadataKey, "mac"))
+	if err != nil {
+		return err
+	}
+
+	mac := sha256.New()
+	err = walk(root, nil, func(n *node, path []string) error {
+		if ct, ok := decode(n.value); ok && n.kind == scalar && n.typ == typeStr {
+			out, err := d.ad.Decrypt(ct, adsecret.Join(path...))
+			if err != nil {
+				return errors.New("value at " + strings.Join(path, "") + ": " + err.Error())
+			}
+			if len(out) == 0 {
+				return errors.New("value at " + strings.Join(path, "") + " is malformed")
+			}
+			n.typ, n.value = out[0], string(out[1:])
+		}
+		mac.Write(macEntry(path, n))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(mac.Sum(nil), expected) != 1 {
+		return ErrMAC
+	}
+	return nil
+}
+
+// walk calls f for n and every node under it, parents first. Path elements
+// are ".key" for mappings and "[index]" for sequences.
+func walk(n *node, path []string, f func(n *node, path []string) error) error {
+	if err := f(n, path); err != nil {
+		return err
+	}
+	switch n.kind {
+	case mapping:
+		for i, k := range n.keys {
+			if err := walk(n.items[i], append(path[:len(path):len(path)], "."+k), f); err != nil {
+				return err
+			}
+		}
+	case sequence:
+		for i, item := range n.items {
+			if err := walk(item, append(path[:len(path):len(path)], "["+strconv.Itoa(i)+"]"), f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// macEntry returns the MAC input of a node: the type and value of a scalar,
+// or the kind and length of a mapping or sequence, so that empty ones count.
+func macEntry(path []string, n *node) []byte {
+	fields := append(path[:len(path):len(path)], string(n.typ), n.value)
+	switch n.kind {
+	case mapping:
+		fields = append(path[:len(path):len(path)], "{}", strconv.Itoa(len(n.items)))
+	case sequence:
+		fields = append(path[:len(path):len(path)], "[]", strconv.Itoa(len(n.items)))
+	}
+	return adsecret.Join(fields...)
+}
+
+func encode(ct []byte) string {
+	return encPrefix + base64.StdEncoding.EncodeToString(ct) + encSuffix
+}
+
+func decode(s string) ([]byte, bool) {
+	if !strings.HasPrefix(s, encPrefix) || !strings.HasSuffix(s, encSuffix) {
+		return nil, false
+	}
+	ct, err := base64.StdEncoding.DecodeString(s[len(encPrefix) : len(s)-len(encSuffix)])
+	return ct, err == nil
+}
+
+func parseJSON(doc []byte) (*node, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	n, err := decodeJSON(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid data after top level value")
+	}
+	return n, nil
+}
+
+func decodeJSON(dec *json.Decoder) (*node, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		n := &node{kind: mapping}
+		if t == '[' {
+			n.kind = sequence
+		}
+		for dec.More() {
+			if n.kind == mapping {
+				k, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				n.keys = append(n.keys, k.(string))
+			}
+			item, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			n.items = append(n.items, item)
+		}
+		if _, err = dec.Token(); err != nil {
+			return nil, err
+		}
+		return n, nil
+	case string:
+		return &node{typ: typeStr, value: t}, nil
+	case json.Number:
+		return &node{typ: typeNum, value: t.String()}, nil
+	case bool:
+		return &node{typ: typeBool, value: strconv.FormatBool(t)}, nil
+	default:
+		return &node{typ: typeNull, value: "null"}, nil
+	}
+}
+
+func formatJSON(n *node) []byte {
+	var b bytes.Buffer
+	writeJSON(&b, n, "")
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+func writeJSON(b *bytes.Buffer, n *node, indent string) {
+	open, close := "{", "}"
+	switch n.kind {
+	case sequence:
+		open, close = "[", "]"
+	case scalar:
+		if n.typ == typeStr {
+			b.WriteString(quoteJSON(n.value))
+		} else {
+			b.WriteString(n.value)
+		}
+		return
+	}
+	if len(n.items) == 0 {
+		b.WriteString(open + close)
+		return
+	}
+	b.WriteString(open + "\n")
+	for i, item := range n.items {
+		b.WriteString(indent + "  ")
+		if n.kind == mapping {
+			b.WriteString(quoteJSON(n.keys[i]) + ": ")
+		}
+		writeJSON(b, item, indent+"  ")
+		if i < len(n.items)-1 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('\n')
+	}
+	b.WriteString(indent + close)
+}
+
+func quoteJSON(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,197 @@
+package docsecret
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/andmarios/crypto/nacl/padsecret"
+)
+
+const testJSON = `{
+  "name": "api",
+  "port": 8080,
+  "ratio": 1e-3,
+  "debug": true,
+  "nothing": null,
+  "database": {
+    "user": "admin",
+    "password": "<p&ss>"
+  },
+  "hosts": [
+    "a.example.com",
+    {
+      "b": [],
+      "c": {}
+    }
+  ]
+}
+`
+
+func newDocSecret(t *testing.T, key string) *DocSecret {
+	c, err := padsecret.New(key, "qwertyuiopasdfghjklzxcvbnm123456", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(c)
+}
+
+func TestJSON(t *testing.T) {
+	d := newDocSecret(t, "qwerty")
+
+	enc, err := d.EncryptJSON([]byte(testJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"admin", "8080", "example.com", "true", "null"} {
+		if bytes.Contains(enc, []byte(s)) {
+			t.Errorf("Encrypted document contains '%s':\n%s", s, enc)
+		}
+	}
+	for _, s := range []string{`"database": {`, `"password": "ENC[nacl,data:`, `"docsecret": {`} {
+		if !bytes.Contains(enc, []byte(s)) {
+			t.Errorf("Encrypted document lacks '%s':\n%s", s, enc)
+		}
+	}
+	if _, err = d.EncryptJSON(enc); err == nil {
+		t.Errorf("EncryptJSON() encrypts a document twice.")
+	}
+
+	dec, err := d.DecryptJSON(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dec) != testJSON {
+		t.Errorf("DecryptJSON() returned:\n%s\nexpected:\n%s", dec, testJSON)
+	}
+
+	if _, err = newDocSecret(t, "other").DecryptJSON(enc); err == nil {
+		t.Errorf("DecryptJSON() succeeds with the wrong key.")
+	}
+	if _, err = d.DecryptJSON([]byte(testJSON)); err == nil {
+		t.Errorf("DecryptJSON() accepts a plaintext document.")
+	}
+}
+
+func TestTamper(t *testing.T) {
+	d := newDocSecret(t, "qwerty")
+	enc, err := d.EncryptJSON([]byte(`{"a": "x", "b": "y", "c": {"d": "z"}, "e": [], "f": {}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err = json.Unmarshal(enc, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	tamper := func(name string, f func(doc map[string]interface{})) {
+		var m map[string]interface{}
+		json.Unmarshal(enc, &m)
+		f(m)
+		out, _ := json.Marshal(m)
+		if _, err := d.DecryptJSON(out); err == nil {
+			t.Errorf("DecryptJSON() does not detect %s.", name)
+		}
+	}
+	tamper("swapped values", func(m map[string]interface{}) { m["a"], m["b"] = m["b"], m["a"] })
+	tamper("moved values", func(m map[string]interface{}) { m["c"].(map[string]interface{})["d"] = m["a"] })
+	tamper("removed fields", func(m map[string]interface{}) { delete(m, "b") })
+	tamper("added fields", func(m map[string]interface{}) { m["e"] = "plain" })
+	tamper("added encrypted fields", func(m map[string]interface{}) { m["c"].(map[string]interface{})["a"] = m["a"] })
+	tamper("added empty sequences", func(m map[string]interface{}) { m["g"] = []interface{}{} })
+	tamper("added empty mappings", func(m map[string]interface{}) { m["c"].(map[string]interface{})["g"] = map[string]interface{}{} })
+	tamper("removed empty sequences", func(m map[string]interface{}) { delete(m, "e") })
+	tamper("removed empty mappings", func(m map[string]interface{}) { delete(m, "f") })
+	tamper("changed collection kinds", func(m map[string]interface{}) { m["e"], m["f"] = m["f"], m["e"] })
+
+	out, _ := json.Marshal(doc)
+	if _, err := d.DecryptJSON(out); err != nil {
+		t.Errorf("DecryptJSON() failed on an untouched document: %v", err)
+	}
+}
+
+func TestYAML(t *testing.T) {
+	d := newDocSecret(t, "qwerty")
+	in, err := ioutil.ReadFile("testdata/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig, err := parseYAML(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := d.EncryptYAML(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(enc, []byte("example.com")) || !bytes.Contains(enc, []byte("\n  password: ENC[nacl,data:")) {
+		t.Errorf("Unexpected encrypted document:\n%s", enc)
+	}
+
+	dec, err := d.DecryptYAML(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := parseYAML(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(orig, restored) {
+		t.Errorf("DecryptYAML() returned a different document:\n%s", dec)
+	}
+	for _, s := range []string{"password: \"s3cr#t: value\"\n", "- it's\n", "- \"yes\"\n", "empty:\n", "options: []\n", "  - host: b.example.com\n    weight: 2\n"} {
+		if !strings.Contains(string(dec), s) {
+			t.Errorf("Decrypted document lacks '%s':\n%s", s, dec)
+		}
+	}
+}
+
+func TestYAMLErrors(t *testing.T) {
+	for _, tc := range []struct{ doc, err string }{
+		{"a: [1, 2]", "flow collections"},
+		{"a: {b: 1}", "flow collections"},
+		{"[a]: 1", "flow collections"},
+		{"a: |\n  text", "block scalars"},
+		{"a: >\n  text", "block scalars"},
+		{"a: &anchor x", "anchors"},
+		{"a: *alias", "anchors"},
+		{"a: !!str x", "tags"},
+		{"&anchor a: x", "anchors"},
+		{"!!str a: x", "tags"},
+		{"? a\n: x", "complex keys"},
+		{": x", "empty keys"},
+		{"a: @x", "can not start with @"},
+		{"a: `x`", "can not start with `"},
+		{"a: 1\na: 2", "duplicate key a"},
+		{"a:\n  b: 1\n  b: 2", "duplicate key b"},
+		{"a: b: c", "own line"},
+		{"a: - b", "own line"},
+		{"a: b:", "own line"},
+		{"a:\n\tb: 1", "tabs"},
+		{"a: 1\n   b: 2", "unexpected indentation"},
+		{"a: hello\n  world", "unexpected indentation"},
+		{"a: 1\n- b", "expected a mapping key"},
+		{"a: \"open", "invalid double quoted"},
+		{"a: \"\\x41\"", "invalid double quoted"},
+		{"a: 'open", "invalid single quoted"},
+		{"a: 1\n---\nb: 2", "multiple documents"},
+		{"a: 1\n...\nb: 2", "multiple documents"},
+		{"--- a: 1", "own line"},
+		{"%YAML 1.2\n---\na: 1", "directives"},
+	} {
+		if _, err := parseYAML([]byte(tc.doc)); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("parseYAML() returned %v for:\n%s", err, tc.doc)
+		}
+	}
+
+	// The subset still takes these.
+	for _, doc := range []string{"a: b:c", "a: http://x", "a: 'b: c'", "a:: b", "-a: 1", "a: -1", "---\na: 1\n...\n"} {
+		if _, err := parseYAML([]byte(doc)); err != nil {
+			t.Errorf("parseYAML() rejects %q: %v", doc, err)
+		}
+	}
+}
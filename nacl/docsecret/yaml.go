@@ -0,0 +1,363 @@
+package docsecret
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A yamlLine is a non empty line, without its indentation and comments.
+type yamlLine struct {
+	indent int
+	text   string
+	num    int
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+}
+
+func yamlError(num int, msg string) error {
+	return errors.New("yaml line " + strconv.Itoa(num) + ": " + msg)
+}
+
+func parseYAML(doc []byte) (*node, error) {
+	p := &yamlParser{}
+	end := false
+	for i, l := range strings.Split(string(doc), "\n") {
+		l = strings.TrimRight(l, " \r")
+		t := strings.TrimLeft(l, " ")
+		if strings.HasPrefix(t, "\t") {
+			return nil, yamlError(i+1, "tabs are not allowed in indentation")
+		}
+		indent := len(l) - len(t)
+		if t = stripComment(t); t == "" {
+			continue
+		}
+		switch {
+		case end && t != "...", indent == 0 && t == "---" && len(p.lines) > 0:
+			return nil, yamlError(i+1, "multiple documents are not supported")
+		case indent == 0 && (t == "---" || t == "..."):
+			end = end || t == "..."
+			continue
+		case indent == 0 && (strings.HasPrefix(t, "--- ") || strings.HasPrefix(t, "... ")):
+			return nil, yamlError(i+1, "document markers have to be on their own line")
+		case indent == 0 && t[0] == '%':
+			return nil, yamlError(i+1, "directives are not supported")
+		}
+		p.lines = append(p.lines, yamlLine{indent, t, i + 1})
+	}
+	if len(p.lines) == 0 {
+		return &node{kind: mapping}, nil
+	}
+	n, err := p.parseNode(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(p.lines) {
+		return nil, yamlError(p.lines[p.i].num, "unexpected indentation")
+	}
+	return n, nil
+}
+
+// stripComment removes a trailing comment, a '#' at the start or after a
+// space, outside quotes.
+func stripComment(t string) string {
+	var quote byte
+	for i := 0; i < len(t); i++ {
+		c := t[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || t[i-1] == ' '):
+			quote = c
+		case c == '#' && (i == 0 || t[i-1] == ' '):
+			return strings.TrimRight(t[:i], " ")
+		}
+	}
+	return t
+}
+
+func isSeqItem(t string) bool {
+	return t == "-" || strings.HasPrefix(t, "- ")
+}
+
+func (p *yamlParser) parseNode(indent int) (*node, error) {
+	l := p.lines[p.i]
+	if isSeqItem(l.text) {
+		return p.parseSeq(indent)
+	}
+	if _, _, ok, err := splitKey(l.text, l.num); err != nil {
+		return nil, err
+	} else if ok {
+		return p.parseMap(indent)
+	}
+	p.i++
+	return parseScalar(l.text, l.num)
+}
+
+func (p *yamlParser) parseSeq(indent int) (*node, error) {
+	n := &node{kind: sequence}
+	for p.i < len(p.lines) && p.lines[p.i].indent == indent && isSeqItem(p.lines[p.i].text) {
+		l := p.lines[p.i]
+		rest := strings.TrimLeft(l.text[1:], " ")
+		var item *node
+		var err error
+		if rest == "" {
+			item, err = p.parseChild(indent, false)
+		} else {
+			// Parse the item as if it started on its own line.
+			p.lines[p.i] = yamlLine{l.indent + len(l.text) - len(rest), rest, l.num}
+			item, err = p.parseNode(p.lines[p.i].indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		n.items = append(n.items, item)
+	}
+	return n, nil
+}
+
+func (p *yamlParser) parseMap(indent int) (*node, error) {
+	n := &node{kind: mapping}
+	seen := make(map[string]bool)
+	for p.i < len(p.lines) && p.lines[p.i].indent == indent {
+		l := p.lines[p.i]
+		key, rest, ok, err := splitKey(l.text, l.num)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, yamlError(l.num, "expected a mapping key")
+		}
+		// Values are bound to their path, which has to be unique.
+		if seen[key] {
+			return nil, yamlError(l.num, "duplicate key "+key)
+		}
+		seen[key] = true
+		var item *node
+		if rest == "" {
+			item, err = p.parseChild(indent, true)
+		} else {
+			p.i++
+			item, err = parseScalar(rest, l.num)
+		}
+		if err != nil {
+			return nil, err
+		}
+		n.keys = append(n.keys, key)
+		n.items = append(n.items, item)
+	}
+	return n, nil
+}
+
+// parseChild parses the value of a key or sequence item that is written on
+// the following lines. It is null if there are none.
+func (p *yamlParser) parseChild(indent int, key bool) (*node, error) {
+	p.i++
+	if p.i < len(p.lines) {
+		next := p.lines[p.i]
+		// A sequence may be indented as much as its key.
+		if next.indent > indent || (key && next.indent == indent && isSeqItem(next.text)) {
+			return p.parseNode(next.indent)
+		}
+	}
+	return &node{typ: typeNull}, nil
+}
+
+// splitKey splits a "key: value" line. ok is false if the line is not a
+// mapping entry.
+func splitKey(t string, num int) (key, rest string, ok bool, err error) {
+	if t[0] == '"' || t[0] == '\'' {
+		end := quotedEnd(t)
+		if end < 0 {
+			return "", "", false, yamlError(num, "unterminated quoted string")
+		}
+		after := t[end+1:]
+		if after != ":" && !strings.HasPrefix(after, ": ") {
+			return "", "", false, nil
+		}
+		k, err := parseScalar(t[:end+1], num)
+		if err != nil {
+			return "", "", false, err
+		}
+		return k.value, strings.TrimLeft(after[1:], " "), true, nil
+	}
+	if strings.HasPrefix(t, "? ") {
+		return "", "", false, yamlError(num, "complex keys are not supported")
+	}
+	i := strings.Index(t, ": ")
+	if i < 0 {
+		if !strings.HasSuffix(t, ":") {
+			return "", "", false, nil
+		}
+		i = len(t) - 1
+	}
+	if strings.ContainsAny(t[:1], "[{") {
+		return "", "", false, nil
+	}
+	key = strings.TrimRight(t[:i], " ")
+	if key == "" {
+		return "", "", false, yamlError(num, "empty keys are not supported")
+	}
+	if err = checkPlain(key, num); err != nil {
+		return "", "", false, err
+	}
+	return key, strings.TrimLeft(t[i+1:], " "), true, nil
+}
+
+// checkPlain rejects the plain scalars that start with an indicator of an
+// unsupported feature, or a reserved one.
+func checkPlain(t string, num int) error {
+	switch {
+	case strings.ContainsAny(t[:1], "[{"):
+		return yamlError(num, "flow collections are not supported")
+	case strings.ContainsAny(t[:1], "|>"):
+		return yamlError(num, "block scalars are not supported")
+	case strings.ContainsAny(t[:1], "&*!"):
+		return yamlError(num, "anchors, aliases and tags are not supported")
+	case strings.ContainsAny(t[:1], "%@`,"):
+		return yamlError(num, "plain scalars can not start with "+t[:1])
+	}
+	return nil
+}
+
+// quotedEnd returns the index of the quote closing the string t starts with.
+func quotedEnd(t string) int {
+	for i := 1; i < len(t); i++ {
+		switch {
+		case t[0] == '"' && t[i] == '\\':
+			i++
+		case t[i] == t[0]:
+			if t[0] == '\'' && i+1 < len(t) && t[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+func parseScalar(t string, num int) (*node, error) {
+	switch {
+	case t[0] == '"':
+		var s string
+		if quotedEnd(t) != len(t)-1 || json.Unmarshal([]byte(t), &s) != nil {
+			return nil, yamlError(num, "invalid double quoted string")
+		}
+		return &node{typ: typeStr, value: s}, nil
+	case t[0] == '\'':
+		if quotedEnd(t) != len(t)-1 {
+			return nil, yamlError(num, "invalid single quoted string")
+		}
+		return &node{typ: typeStr, value: strings.ReplaceAll(t[1:len(t)-1], "''", "'")}, nil
+	case t == "[]":
+		return &node{kind: sequence}, nil
+	case t == "{}":
+		return &node{kind: mapping}, nil
+	}
+	if err := checkPlain(t, num); err != nil {
+		return nil, err
+	}
+	// Only the value of a key is parsed here without looking for these.
+	if isSeqItem(t) || strings.HasPrefix(t, "? ") || strings.Contains(t, ": ") || strings.HasSuffix(t, ":") {
+		return nil, yamlError(num, "nested collections have to start on their own line")
+	}
+	return &node{typ: resolve(t), value: t}, nil
+}
+
+var (
+	yamlNumber = regexp.MustCompile(`^([-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?|0x[0-9a-fA-F]+|0o[0-7]+|[-+]?\.(inf|Inf|INF)|\.(nan|NaN|NAN))$`)
+	// Booleans of YAML 1.1, quoted on output so older parsers keep them strings.
+	yamlOldBool = regexp.MustCompile(`^(y|Y|yes|Yes|YES|n|N|no|No|NO|on|On|ON|off|Off|OFF)$`)
+)
+
+// resolve returns the type of a plain scalar.
+func resolve(t string) byte {
+	switch t {
+	case "", "~", "null", "Null", "NULL":
+		return typeNull
+	case "true", "True", "TRUE", "false", "False", "FALSE":
+		return typeBool
+	}
+	if yamlNumber.MatchString(t) {
+		return typeNum
+	}
+	return typeStr
+}
+
+func formatYAML(n *node) []byte {
+	var b bytes.Buffer
+	if n.kind == scalar || len(n.items) == 0 {
+		b.WriteString(yamlScalar(n) + "\n")
+	} else {
+		writeYAML(&b, n, 0)
+	}
+	return b.Bytes()
+}
+
+// writeYAML writes a non empty mapping or sequence, one line per entry.
+func writeYAML(b *bytes.Buffer, n *node, indent int) {
+	pad := strings.Repeat(" ", indent)
+	for i, item := range n.items {
+		b.WriteString(pad)
+		if n.kind == mapping {
+			b.WriteString(yamlString(n.keys[i]) + ":")
+		} else {
+			b.WriteString("-")
+		}
+		switch {
+		case item.kind == scalar || len(item.items) == 0:
+			if s := yamlScalar(item); s != "" {
+				b.WriteString(" " + s)
+			}
+			b.WriteByte('\n')
+		case n.kind == mapping:
+			b.WriteByte('\n')
+			writeYAML(b, item, indent+2)
+		default:
+			// Write the first entry of a nested collection on the item's line.
+			var c bytes.Buffer
+			writeYAML(&c, item, indent+2)
+			b.WriteString(" ")
+			b.Write(c.Bytes()[indent+2:])
+		}
+	}
+}
+
+func yamlScalar(n *node) string {
+	switch {
+	case n.kind == sequence:
+		return "[]"
+	case n.kind == mapping:
+		return "{}"
+	case n.typ == typeStr:
+		return yamlString(n.value)
+	}
+	return n.value
+}
+
+// yamlString writes s as a plain scalar if it reads back as the same string,
+// double quoted otherwise.
+func yamlString(s string) string {
+	if s == "" || resolve(s) != typeStr || yamlOldBool.MatchString(s) ||
+		strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@` ") || strings.HasSuffix(s, " ") ||
+		strings.HasSuffix(s, ":") || strings.Contains(s, ": ") || strings.Contains(s, " #") {
+		return quoteJSON(s)
+	}
+	for _, r := range s {
+		if r < ' ' || r == 0x7f {
+			return quoteJSON(s)
+		}
+	}
+	return s
+}
@@ -0,0 +1,247 @@
+/*
+Package envsecret loads .env files whose values are encrypted with padsecret
+or saltsecret.
+
+A .env file holds one KEY=value pair per line. Blank lines, lines starting
+with '#' and an "export " prefix are ignored. Values may be double quoted
+(with Go escapes), single quoted (literal) or bare, where a " #" starts a
+comment. Encrypted values look like
+
+	DB_PASSWORD=enc:v1:<base64 ciphertext>
+
+The ciphertext is bound to the name of the variable as associated data
+(through adsecret), so encrypted values can not be moved to another variable.
+Values without the "enc:v1:" prefix are read as they are, so a file may mix
+secrets and plain settings. EncryptFile encrypts the plain values of a file,
+keeping comments and order.
+
+Decrypted values stay in memory: Read returns them as a map and Load sets
+them as environment variables of the current process.
+*/
+package envsecret
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/andmarios/crypto/nacl/adsecret"
+)
+
+// Prefix marks encrypted values.
+const Prefix = "enc:v1:"
+
+// An EnvSecret holds the cipher used for the values.
+type EnvSecret struct {
+	ad *adsecret.ADSecret
+}
+
+// New creates a new EnvSecret instance that decrypts with c, usually a
+// padsecret.PadSecret or saltsecret.SaltSecret.
+func New(c adsecret.Cipher) *EnvSecret {
+	return &EnvSecret{adsecret.New(c)}
+}
+
+// LoadKey returns the key stored in file, or, if file is empty, in the
+// environment variable env. A trailing newline in the file is ignored.
+func LoadKey(file, env string) ([]byte, error) {
+	if file == "" {
+		if key := os.Getenv(env); key != "" {
+			return []byte(key), nil
+		}
+		return nil, errors.New("environment variable " + env + " is not set")
+	}
+	key, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key = bytes.TrimSuffix(bytes.TrimSuffix(key, []byte("\n")), []byte("\r"))
+	if len(key) == 0 {
+		return nil, errors.New("key file " + file + " is empty")
+	}
+	return key, nil
+}
+
+// EncryptValue encrypts the value of the variable name and adds the Prefix.
+func (e EnvSecret) EncryptValue(name, value string) (string, error) {
+	out, err := e.ad.Encrypt([]byte(value), valueAD(name))
+	if err != nil {
+		return "", err
+	}
+	return Prefix + base64.StdEncoding.EncodeToString(out), nil
+}
+
+// DecryptValue decrypts the value of the variable name, encrypted by
+// EncryptValue. Values without the Prefix are returned unchanged.
+func (e EnvSecret) DecryptValue(name, value string) (string, error) {
+	if !strings.HasPrefix(value, Prefix) {
+		return value, nil
+	}
+	in, err := base64.StdEncoding.DecodeString(value[len(Prefix):])
+	if err != nil {
+		return "", err
+	}
+	out, err := e.ad.Decrypt(in, valueAD(name))
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func valueAD(name string) []byte {
+	return adsecret.Join("envsecret", name)
+}
+
+// Parse reads a .env file from r and returns its decrypted values.
+func (e EnvSecret) Parse(r io.Reader) (map[string]string, error) {
+	env := make(map[string]string)
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		key, value, ok, err := parseLine(s.Text())
+		if err != nil {
+			return nil, errors.New("line " + strconv.Itoa(n) + ": " + err.Error())
+		}
+		if !ok {
+			continue
+		}
+		if env[key], err = e.DecryptValue(key, value); err != nil {
+			return nil, errors.New("line " + strconv.Itoa(n) + ": can not decrypt " + key + ": " + err.Error())
+		}
+	}
+	return env, s.Err()
+}
+
+// Read reads the .env files names and returns their decrypted values. Later
+// files override earlier ones.
+func (e EnvSecret) Read(names ...string) (map[string]string, error) {
+	env := make(map[string]string)
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		m, err := e.Parse(f)
+		f.Close()
+		if err != nil {
+			return nil, errors.New(name + ": " + err.Error())
+		}
+		for k, v := range m {
+			env[k] = v
+		}
+	}
+	return env, nil
+}
+
+// Load reads the .env files names and sets their decrypted values as
+// environment variables. Variables that are already set are not overridden.
+func (e EnvSecret) Load(names ...string) error {
+	env, err := e.Read(names...)
+	if err != nil {
+		return err
+	}
+	for k, v := range env {
+		if _, ok := os.LookupEnv(k); ok {
+			continue
+		}
+		if err = os.Setenv(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncryptFile encrypts the values of a .env file that are not encrypted yet.
+// Comments, blank lines and the order of the keys are kept.
+func (e EnvSecret) EncryptFile(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		key, value, ok, err := parseLine(line)
+		if err != nil {
+			return nil, errors.New("line " + strconv.Itoa(n) + ": " + err.Error())
+		}
+		if ok && !strings.HasPrefix(value, Prefix) {
+			if value, err = e.EncryptValue(key, value); err != nil {
+				return nil, err
+			}
+			export := ""
+			if strings.HasPrefix(strings.TrimSpace(line), "export ") {
+				export = "export "
+			}
+			line = export + key + "=" + value
+		}
+		out.WriteString(line + "\n")
+	}
+	return out.Bytes(), s.Err()
+}
+
+var validKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// parseLine parses a line of a .env file. ok is false for blank lines and
+// comments.
+func parseLine(line string) (key, value string, ok bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", "", false, nil
+	}
+	line = strings.TrimPrefix(line, "export ")
+	i := strings.IndexByte(line, '=')
+	if i < 0 {
+		return "", "", false, errors.New("expected KEY=value")
+	}
+	key = strings.TrimSpace(line[:i])
+	if !validKey.MatchString(key) {
+		return "", "", false, errors.New("invalid key '" + key + "'")
+	}
+	value = strings.TrimSpace(line[i+1:])
+
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end := closingQuote(value)
+		if end < 0 || !isComment(value[end+1:]) {
+			return "", "", false, errors.New("invalid double quoted value of " + key)
+		}
+		if value, err = strconv.Unquote(value[:end+1]); err != nil {
+			return "", "", false, errors.New("invalid double quoted value of " + key)
+		}
+	case strings.HasPrefix(value, "'"):
+		end := strings.IndexByte(value[1:], '\'') + 1
+		if end < 1 || !isComment(value[end+1:]) {
+			return "", "", false, errors.New("invalid single quoted value of " + key)
+		}
+		value = value[1:end]
+	default:
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+	}
+	return key, value, true, nil
+}
+
+// closingQuote returns the index of the quote closing the double quoted
+// string s starts with.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// isComment reports whether what follows a quoted value is blank or a comment.
+func isComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || s[0] == '#'
+}
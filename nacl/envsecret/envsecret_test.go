@@ -0,0 +1,137 @@
+package envsecret
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/andmarios/crypto/nacl/padsecret"
+)
+
+const testEnv = `# Database settings
+export DB_HOST=db.example.com
+DB_PASSWORD="p@ss \"word\"\n" # the password
+API_TOKEN='lit $eral'
+PORT=8080 # plain
+
+EMPTY=
+`
+
+var testValues = map[string]string{
+	"DB_HOST":     "db.example.com",
+	"DB_PASSWORD": "p@ss \"word\"\n",
+	"API_TOKEN":   "lit $eral",
+	"PORT":        "8080",
+	"EMPTY":       "",
+}
+
+func newEnvSecret(t *testing.T, key string) *EnvSecret {
+	c, err := padsecret.New(key, "qwertyuiopasdfghjklzxcvbnm123456", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(c)
+}
+
+func TestPackage(t *testing.T) {
+	e := newEnvSecret(t, "qwerty")
+
+	plain, err := e.Parse(strings.NewReader(testEnv))
+	if err != nil || !reflect.DeepEqual(plain, testValues) {
+		t.Errorf("Parse() returned %q, %v, expected %q.", plain, err, testValues)
+	}
+
+	enc, err := e.EncryptFile([]byte(testEnv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(enc), "\n")
+	if lines[0] != "# Database settings" || !strings.HasPrefix(lines[1], "export DB_HOST="+Prefix) || lines[5] != "" {
+		t.Errorf("EncryptFile() did not keep the file layout:\n%s", enc)
+	}
+	for _, v := range []string{"example.com", "word", "eral", "8080"} {
+		if strings.Contains(string(enc), v) {
+			t.Errorf("EncryptFile() left '%s' in plaintext:\n%s", v, enc)
+		}
+	}
+	again, err := e.EncryptFile(enc)
+	if err != nil || string(again) != string(enc) {
+		t.Errorf("EncryptFile() encrypted values twice.")
+	}
+
+	dec, err := e.Parse(strings.NewReader(string(enc)))
+	if err != nil || !reflect.DeepEqual(dec, testValues) {
+		t.Errorf("Parse() returned %q, %v, expected %q.", dec, err, testValues)
+	}
+	if _, err = newEnvSecret(t, "other").Parse(strings.NewReader(string(enc))); err == nil {
+		t.Errorf("Parse() succeeds with the wrong key.")
+	}
+
+	for _, bad := range []string{"NOVALUE", "1KEY=x", `KEY="open`, `KEY="a" b`, "KEY='a' b", "KEY=" + Prefix + "!!"} {
+		if _, err = e.Parse(strings.NewReader(bad)); err == nil {
+			t.Errorf("Parse() accepts '%s'.", bad)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	e := newEnvSecret(t, "qwerty")
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first.env"), filepath.Join(dir, "second.env")
+	a, _ := e.EncryptValue("ENVSECRET_A", "first")
+	b, _ := e.EncryptValue("ENVSECRET_B", "second")
+	ioutil.WriteFile(first, []byte("ENVSECRET_A="+a+"\nENVSECRET_B=first\nENVSECRET_SET=file\n"), 0600)
+	ioutil.WriteFile(second, []byte("ENVSECRET_B="+b+"\n"), 0600)
+
+	os.Setenv("ENVSECRET_SET", "env")
+	defer func() {
+		for _, k := range []string{"ENVSECRET_A", "ENVSECRET_B", "ENVSECRET_SET"} {
+			os.Unsetenv(k)
+		}
+	}()
+	if err := e.Load(first, second); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{"ENVSECRET_A": "first", "ENVSECRET_B": "second", "ENVSECRET_SET": "env"} {
+		if got := os.Getenv(k); got != v {
+			t.Errorf("After Load() %s is '%s', expected '%s'.", k, got, v)
+		}
+	}
+	if err := e.Load(filepath.Join(dir, "missing.env")); err == nil {
+		t.Errorf("Load() accepts missing files.")
+	}
+}
+
+func TestSwap(t *testing.T) {
+	e := newEnvSecret(t, "qwerty")
+	enc, err := e.EncryptFile([]byte("DB_PASSWORD=hunter2\nAPI_TOKEN=0123\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(enc), "\n")
+	v := func(l string) string { return l[strings.IndexByte(l, '=')+1:] }
+	swapped := "DB_PASSWORD=" + v(lines[1]) + "\nAPI_TOKEN=" + v(lines[0]) + "\n"
+	if _, err = e.Parse(strings.NewReader(swapped)); err == nil {
+		t.Errorf("Parse() accepts values swapped between variables.")
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "key")
+	ioutil.WriteFile(file, []byte("from file\n"), 0600)
+	os.Setenv("ENVSECRET_KEY", "from env")
+	defer os.Unsetenv("ENVSECRET_KEY")
+
+	if key, err := LoadKey(file, "ENVSECRET_KEY"); err != nil || string(key) != "from file" {
+		t.Errorf("LoadKey() returned '%s', %v.", key, err)
+	}
+	if key, err := LoadKey("", "ENVSECRET_KEY"); err != nil || string(key) != "from env" {
+		t.Errorf("LoadKey() returned '%s', %v.", key, err)
+	}
+	if _, err := LoadKey("", "ENVSECRET_UNSET"); err == nil {
+		t.Errorf("LoadKey() accepts unset variables.")
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Package flagsecret provides flag.Value and environment variable helpers for
+secrets passed on the command line in encrypted form.
+
+Passwords given as flags end up in shell history and service definitions.
+With flagsecret they are given encrypted instead, in the format of envsecret:
+
+	server -db-password enc:v1:<base64 ciphertext>
+
+Values are decrypted when they are parsed, with the keys of a process-wide
+keyring (see AddKey), and the plaintext is only returned by Value. A Secret
+prints as "[redacted]", so flag.PrintDefaults and fmt can not leak it.
+
+Plaintext values are rejected. Encrypt, or envsecret.EncryptValue with an
+empty name, creates encrypted values. Values are not bound to the name of a
+flag, since a flag.Value does not know it.
+*/
+package flagsecret
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/andmarios/crypto/nacl/adsecret"
+	"github.com/andmarios/crypto/nacl/envsecret"
+)
+
+// Redacted is what a Secret that is set prints as.
+const Redacted = "[redacted]"
+
+var (
+	keyringMu sync.RWMutex
+	keyring   []*envsecret.EnvSecret
+)
+
+// AddKey adds a cipher, usually a padsecret.PadSecret or saltsecret.SaltSecret,
+// to the keyring. Values are decrypted with the keys in the order they were
+// added, so an old key may be kept around while values are re-encrypted.
+// Keys have to be added before flags are parsed.
+func AddKey(c adsecret.Cipher) {
+	keyringMu.Lock()
+	keyring = append(keyring, envsecret.New(c))
+	keyringMu.Unlock()
+}
+
+// Encrypt encrypts a value with the first key of the keyring.
+func Encrypt(value string) (string, error) {
+	keyringMu.RLock()
+	defer keyringMu.RUnlock()
+	if len(keyring) == 0 {
+		return "", errors.New("flagsecret keyring is empty")
+	}
+	return keyring[0].EncryptValue("", value)
+}
+
+func decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, envsecret.Prefix) {
+		return "", errors.New("value is not encrypted")
+	}
+	keyringMu.RLock()
+	defer keyringMu.RUnlock()
+	if len(keyring) == 0 {
+		return "", errors.New("flagsecret keyring is empty")
+	}
+	for _, k := range keyring {
+		if out, err := k.DecryptValue("", value); err == nil {
+			return out, nil
+		}
+	}
+	return "", errors.New("value can not be decrypted with any key")
+}
+
+// A Secret holds a decrypted value. It implements flag.Value.
+type Secret struct {
+	value string
+	set   bool
+}
+
+// String defines a Secret flag on flag.CommandLine.
+func String(name, usage string) *Secret {
+	s := new(Secret)
+	flag.Var(s, name, usage)
+	return s
+}
+
+// Env returns the Secret held, encrypted, by the environment variable name.
+func Env(name string) (*Secret, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, errors.New("environment variable " + name + " is not set")
+	}
+	s := new(Secret)
+	if err := s.Set(v); err != nil {
+		return nil, errors.New("environment variable " + name + ": " + err.Error())
+	}
+	return s, nil
+}
+
+// Set decrypts value with the keyring and stores the plaintext.
+func (s *Secret) Set(value string) error {
+	out, err := decrypt(value)
+	if err != nil {
+		return err
+	}
+	s.value, s.set = out, true
+	return nil
+}
+
+// Value returns the plaintext.
+func (s *Secret) Value() string {
+	return s.value
+}
+
+// IsSet reports whether a value was set.
+func (s *Secret) IsSet() bool {
+	return s.set
+}
+
+// String returns Redacted if a value is set, an empty string otherwise.
+func (s Secret) String() string {
+	if !s.set {
+		return ""
+	}
+	return Redacted
+}
+
+// GoString redacts the value from %#v too.
+func (s Secret) GoString() string {
+	return "flagsecret.Secret{" + s.String() + "}"
+}
@@ -0,0 +1,102 @@
+package flagsecret
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/andmarios/crypto/nacl/envsecret"
+	"github.com/andmarios/crypto/nacl/padsecret"
+)
+
+const pad = "qwertyuiopasdfghjklzxcvbnm123456"
+
+func resetKeyring() {
+	keyringMu.Lock()
+	keyring = nil
+	keyringMu.Unlock()
+}
+
+func TestFlag(t *testing.T) {
+	defer resetKeyring()
+	s := new(Secret)
+	if err := s.Set(envsecret.Prefix + "AAAA"); err == nil {
+		t.Errorf("Set() succeeds with an empty keyring.")
+	}
+
+	c, _ := padsecret.New("qwerty", pad, false)
+	AddKey(c)
+	enc, err := Encrypt("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(&out)
+	fs.Var(s, "password", "the database password")
+	if err = fs.Parse([]string{"-password", enc}); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsSet() || s.Value() != "hunter2" {
+		t.Errorf("Value() returned '%s', expected 'hunter2'.", s.Value())
+	}
+	fs.PrintDefaults()
+	printed := out.String() + fmt.Sprintf("%v %s %+v %#v", s, s, *s, s)
+	if strings.Contains(printed, "hunter2") {
+		t.Errorf("Secret leaked its value: %s", printed)
+	}
+	if s.String() != Redacted {
+		t.Errorf("String() returned '%s'.", s.String())
+	}
+
+	if err = fs.Parse([]string{"-password", "hunter2"}); err == nil {
+		t.Errorf("Parse() accepts plaintext values.")
+	}
+	if err = new(Secret).Set(envsecret.Prefix + "!!"); err == nil {
+		t.Errorf("Set() accepts invalid base64.")
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	defer resetKeyring()
+	oc, _ := padsecret.New("old", pad, false)
+	nc, _ := padsecret.New("new", pad, false)
+	AddKey(oc)
+	old, _ := Encrypt("old secret")
+	resetKeyring()
+
+	AddKey(nc)
+	if err := new(Secret).Set(old); err == nil {
+		t.Errorf("Set() decrypts values of keys not in the keyring.")
+	}
+	AddKey(oc)
+	s := new(Secret)
+	if err := s.Set(old); err != nil || s.Value() != "old secret" {
+		t.Errorf("Set() returned '%s', %v with the old key in the keyring.", s.Value(), err)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	defer resetKeyring()
+	c, _ := padsecret.New("qwerty", pad, false)
+	AddKey(c)
+	enc, _ := Encrypt("token")
+	os.Setenv("FLAGSECRET_TOKEN", enc)
+	os.Setenv("FLAGSECRET_PLAIN", "token")
+	defer os.Unsetenv("FLAGSECRET_TOKEN")
+	defer os.Unsetenv("FLAGSECRET_PLAIN")
+
+	if s, err := Env("FLAGSECRET_TOKEN"); err != nil || s.Value() != "token" {
+		t.Errorf("Env() returned %v, %v.", s, err)
+	}
+	if _, err := Env("FLAGSECRET_PLAIN"); err == nil {
+		t.Errorf("Env() accepts plaintext values.")
+	}
+	if _, err := Env("FLAGSECRET_UNSET"); err == nil {
+		t.Errorf("Env() accepts unset variables.")
+	}
+}
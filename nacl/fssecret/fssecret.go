@@ -0,0 +1,366 @@
+/*
+Package fssecret serves a directory of encrypted files as an io/fs.FS, so
+that http.FileServer(http.FS(...)), template.ParseFS and other fs.FS users
+work directly on encrypted assets.
+
+An FS wraps another fs.FS, such as an os.DirFS or an embed.FS, and decrypts
+files with padsecret or saltsecret when they are opened. Files are decrypted
+whole and kept in memory while open. The plaintext path of a file is bound
+to its contents as associated data (see adsecret), so encrypted files can not
+be swapped or moved; EncryptFile encrypts a file for its path.
+
+Stat and DirEntry.Info decrypt a file once to find its plaintext size, and
+remember it while the stored file keeps its size and modification time.
+
+File names can be left as they are or hidden. With a NameCipher, every path
+component is stored encrypted and FS maps the plaintext names to the stored
+ones; ReadDir decrypts the names and skips those that do not decrypt.
+SIVNames provides a NameCipher with deterministic encryption, which it needs
+to find a file from its plaintext name; namesecret provides a more compact
+one. A NameCipher that is also a Shortener stores names too long for the
+filesystem under a shorter name, with the encrypted name in a sidecar file.
+
+EncryptDir writes such a directory from a tree of plain files.
+*/
+package fssecret
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andmarios/crypto/nacl/adsecret"
+)
+
+// A NameCipher encrypts the path components of file names. dir is the
+// plaintext path of the parent directory, "." for the root.
+type NameCipher interface {
+	EncryptName(dir, name string) (string, error)
+	DecryptName(dir, name string) (string, error)
+}
+
+// A Shortener is a NameCipher that stores long encrypted names under a
+// shorter name. The encrypted name is kept in a sidecar file, next to the
+// shortened one.
+type Shortener interface {
+	// Shorten returns the stored name and the sidecar name of a long
+	// encrypted name, or ok false if the name can be stored as is.
+	Shorten(encrypted string) (stored, sidecar string, ok bool)
+	// Sidecar returns the sidecar name of a stored name, if it is a
+	// shortened one.
+	Sidecar(stored string) (sidecar string, ok bool)
+}
+
+// An FS decrypts the files of an underlying fs.FS. It implements fs.FS,
+// fs.ReadFileFS, fs.ReadDirFS and fs.StatFS.
+type FS struct {
+	fsys  fs.FS
+	c     adsecret.Cipher
+	names NameCipher
+
+	mu    sync.Mutex
+	sizes map[sizeKey]int64
+}
+
+// A sizeKey identifies a version of a stored file whose plaintext size is
+// known.
+type sizeKey struct {
+	stored  string
+	size    int64
+	modTime time.Time
+}
+
+// New creates an FS that decrypts the files of fsys with c, usually a
+// padsecret.PadSecret or saltsecret.SaltSecret. If names is nil, file names
+// are not encrypted.
+func New(fsys fs.FS, c adsecret.Cipher, names NameCipher) *FS {
+	return &FS{fsys: fsys, c: c, names: names, sizes: make(map[sizeKey]int64)}
+}
+
+// fileAD is the associated data of the file at the plaintext path name.
+func fileAD(name string) []byte {
+	return adsecret.Join("fssecret file", name)
+}
+
+// EncryptFile encrypts data with c for the file at the plaintext path name,
+// as an FS expects it.
+func EncryptFile(c adsecret.Cipher, name string, data []byte) ([]byte, error) {
+	return adsecret.New(c).Encrypt(data, fileAD(name))
+}
+
+// DecryptFile decrypts data, the file at the plaintext path name, with c.
+func DecryptFile(c adsecret.Cipher, name string, data []byte) ([]byte, error) {
+	return adsecret.New(c).Decrypt(data, fileAD(name))
+}
+
+// stored returns the name of a file in the underlying fs.FS.
+func (f *FS) stored(name string) (string, error) {
+	if f.names == nil || name == "." {
+		return name, nil
+	}
+	dir, out := ".", ""
+	for _, p := range splitPath(name) {
+		e, _, err := f.component(dir, p)
+		if err != nil {
+			return "", err
+		}
+		out = path.Join(out, e)
+		dir = path.Join(dir, p)
+	}
+	return out, nil
+}
+
+// component returns the stored name of name in dir and, if it was shortened,
+// its encrypted name.
+func (f *FS) component(dir, name string) (stored, encrypted string, err error) {
+	e, err := f.names.EncryptName(dir, name)
+	if err != nil {
+		return "", "", err
+	}
+	if sh, ok := f.names.(Shortener); ok {
+		if s, _, ok := sh.Shorten(e); ok {
+			return s, e, nil
+		}
+	}
+	return e, "", nil
+}
+
+func splitPath(name string) []string {
+	var parts []string
+	for name != "." {
+		parts = append([]string{path.Base(name)}, parts...)
+		name = path.Dir(name)
+	}
+	return parts
+}
+
+// pathError rewrites errors of the underlying fs.FS, which hold the stored
+// names, to hold the plaintext name.
+func pathError(op, name string, err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		err = pe.Err
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// Open opens the named file, decrypting it.
+func (f *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	sname, err := f.stored(name)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	fi, err := fs.Stat(f.fsys, sname)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	info := &fileInfo{name: path.Base(name), mode: fi.Mode(), modTime: fi.ModTime()}
+	if fi.IsDir() {
+		return &dir{fsys: f, name: name, info: info}, nil
+	}
+	data, err := f.read(name, sname)
+	if err != nil {
+		return nil, err
+	}
+	info.size = int64(len(data))
+	f.mu.Lock()
+	f.sizes[sizeKey{sname, fi.Size(), fi.ModTime()}] = info.size
+	f.mu.Unlock()
+	return &file{Reader: bytes.NewReader(data), info: info}, nil
+}
+
+func (f *FS) read(name, sname string) ([]byte, error) {
+	data, err := fs.ReadFile(f.fsys, sname)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	if data, err = DecryptFile(f.c, name, data); err != nil {
+		return nil, pathError("open", name, err)
+	}
+	return data, nil
+}
+
+// ReadFile reads and decrypts the named file.
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	sname, err := f.stored(name)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	return f.read(name, sname)
+}
+
+// Stat returns the FileInfo of the named file. The size of a file is its
+// plaintext size, so the file is decrypted unless its size is known.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	sname, err := f.stored(name)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	fi, err := fs.Stat(f.fsys, sname)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	f.mu.Lock()
+	size, ok := f.sizes[sizeKey{sname, fi.Size(), fi.ModTime()}]
+	f.mu.Unlock()
+	if fi.IsDir() || ok {
+		return &fileInfo{name: path.Base(name), size: size, mode: fi.Mode(), modTime: fi.ModTime()}, nil
+	}
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	defer file.Close()
+	return file.Stat()
+}
+
+// ReadDir reads the named directory and returns its entries sorted by their
+// plaintext names.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	sname, err := f.stored(name)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	stored, err := fs.ReadDir(f.fsys, sname)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	entries := make([]fs.DirEntry, 0, len(stored))
+	sh, _ := f.names.(Shortener)
+	for _, e := range stored {
+		n := e.Name()
+		if sh != nil {
+			if sidecar, ok := sh.Sidecar(n); ok {
+				b, err := fs.ReadFile(f.fsys, path.Join(sname, sidecar))
+				if err != nil {
+					continue
+				}
+				// The sidecar must belong to this name, or names could
+				// be swapped between sidecars.
+				if short, _, ok := sh.Shorten(string(b)); !ok || short != n {
+					continue
+				}
+				n = string(b)
+			}
+		}
+		if f.names != nil {
+			if n, err = f.names.DecryptName(name, n); err != nil {
+				// Not one of ours, such as a manifest.
+				continue
+			}
+		}
+		entries = append(entries, &dirEntry{fsys: f, path: path.Join(name, n), name: n, stored: e})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// A file is an open, decrypted file.
+type file struct {
+	*bytes.Reader
+	info *fileInfo
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *file) Close() error               { return nil }
+
+// A dir is an open directory.
+type dir struct {
+	fsys    *FS
+	name    string
+	info    *fileInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dir) Close() error               { return nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir returns the next n entries, or all remaining entries if n <= 0, as
+// described by fs.ReadDirFile.
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.read = entries, true
+	}
+	if n <= 0 {
+		out := d.entries
+		d.entries = nil
+		return out, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	out := d.entries[:n:n]
+	d.entries = d.entries[n:]
+	return out, nil
+}
+
+// A dirEntry is an entry of a directory with its plaintext name.
+type dirEntry struct {
+	fsys   *FS
+	path   string
+	name   string
+	stored fs.DirEntry
+}
+
+func (e *dirEntry) Name() string      { return e.name }
+func (e *dirEntry) IsDir() bool       { return e.stored.IsDir() }
+func (e *dirEntry) Type() fs.FileMode { return e.stored.Type() }
+
+// Info returns the FileInfo of the entry. Files are decrypted to find their
+// size, unless it is known.
+func (e *dirEntry) Info() (fs.FileInfo, error) {
+	if e.stored.IsDir() {
+		fi, err := e.stored.Info()
+		if err != nil {
+			return nil, err
+		}
+		return &fileInfo{name: e.name, mode: fi.Mode(), modTime: fi.ModTime()}, nil
+	}
+	return e.fsys.Stat(e.path)
+}
+
+func (e *dirEntry) String() string {
+	return fs.FormatDirEntry(e)
+}
+
+// fileInfo describes a decrypted file.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return nil }
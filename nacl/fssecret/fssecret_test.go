@@ -0,0 +1,187 @@
+package fssecret
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/andmarios/crypto/nacl/padsecret"
+	"github.com/andmarios/crypto/nacl/saltsecret"
+	"github.com/andmarios/crypto/nacl/sivsecret"
+)
+
+var (
+	key = "qwerty"
+	pad = "qwertyuiopasdfghjklzxcvbnm123456"
+)
+
+var assets = fstest.MapFS{
+	"index.html":            {Data: []byte("<h1>secret project</h1>")},
+	"css/site.css":          {Data: []byte("body { color: red; }")},
+	"templates/hello.tmpl":  {Data: []byte(`Hello {{.}}!`)},
+	"templates/nested/x.md": {Data: []byte("# x")},
+	"empty.txt":             {Data: []byte{}},
+}
+
+// encrypted encrypts assets into a temporary directory.
+func encrypted(t *testing.T, names NameCipher) (string, *padsecret.PadSecret) {
+	c, err := padsecret.New(key, pad, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err = EncryptDir(dir, assets, c, names); err != nil {
+		t.Fatal(err)
+	}
+	return dir, c
+}
+
+func sivNamesForTest(t *testing.T) NameCipher {
+	siv, err := sivsecret.New(key, pad, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return SIVNames(siv)
+}
+
+func TestFS(t *testing.T) {
+	for _, names := range []NameCipher{nil, sivNamesForTest(t)} {
+		dir, c := encrypted(t, names)
+		fsys := New(os.DirFS(dir), c, names)
+		if err := fstest.TestFS(fsys, "index.html", "css/site.css", "templates/hello.tmpl", "templates/nested/x.md", "empty.txt"); err != nil {
+			t.Errorf("Names %T: %v", names, err)
+		}
+		b, err := fsys.ReadFile("css/site.css")
+		if err != nil || string(b) != "body { color: red; }" {
+			t.Errorf("Names %T: ReadFile returned %q, %v.", names, b, err)
+		}
+		if _, err = fsys.Open("nope.txt"); !os.IsNotExist(err) || !strings.Contains(err.Error(), "nope.txt") {
+			t.Errorf("Names %T: opening a missing file returned %v.", names, err)
+		}
+		if _, err = New(os.DirFS(dir), saltsecret.New([]byte(key), false), names).ReadFile("index.html"); err == nil {
+			t.Errorf("Names %T: a wrong key decrypts files.", names)
+		}
+	}
+}
+
+func TestHiddenNames(t *testing.T) {
+	names := sivNamesForTest(t)
+	dir, c := encrypted(t, names)
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if strings.Contains(p, "index") || strings.Contains(p, "templates") || strings.Contains(p, "css") {
+			t.Errorf("Stored path %s holds plaintext names.", p)
+		}
+		if !fi.IsDir() {
+			b, _ := ioutil.ReadFile(p)
+			if bytes.Contains(b, []byte("secret project")) {
+				t.Errorf("Stored file %s holds plaintext.", p)
+			}
+		}
+		return nil
+	})
+
+	// Files that are not ours are skipped.
+	ioutil.WriteFile(filepath.Join(dir, "MANIFEST"), []byte("x"), 0644)
+	fsys := New(os.DirFS(dir), c, names)
+	entries, err := fsys.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	if strings.Join(got, " ") != "css empty.txt index.html templates" {
+		t.Errorf("ReadDir returned %v.", got)
+	}
+
+	// Names are bound to their directory.
+	a, _ := names.EncryptName(".", "x.md")
+	b, _ := names.EncryptName("templates/nested", "x.md")
+	if a == b {
+		t.Errorf("Equal names in different directories are encrypted the same.")
+	}
+	if _, err = names.DecryptName("templates", b); err == nil {
+		t.Errorf("A name decrypts in another directory.")
+	}
+}
+
+func TestHTTPAndTemplates(t *testing.T) {
+	names := sivNamesForTest(t)
+	dir, c := encrypted(t, names)
+	fsys := New(os.DirFS(dir), c, names)
+
+	srv := httptest.NewServer(http.FileServer(http.FS(fsys)))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/css/site.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || string(b) != "body { color: red; }" || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/css") {
+		t.Errorf("FileServer returned %d %q (%s).", resp.StatusCode, b, resp.Header.Get("Content-Type"))
+	}
+
+	tmpl, err := template.ParseFS(fsys, "templates/*.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err = tmpl.ExecuteTemplate(&out, "hello.tmpl", "world"); err != nil || out.String() != "Hello world!" {
+		t.Errorf("Template returned %q, %v.", out.String(), err)
+	}
+}
+
+// countingCipher counts decryptions.
+type countingCipher struct {
+	*padsecret.PadSecret
+	decrypted int
+}
+
+func (c *countingCipher) Decrypt(msg []byte) ([]byte, error) {
+	c.decrypted++
+	return c.PadSecret.Decrypt(msg)
+}
+
+func TestStatSize(t *testing.T) {
+	dir, c := encrypted(t, nil)
+	cc := &countingCipher{PadSecret: c}
+	fsys := New(os.DirFS(dir), cc, nil)
+	for i := 0; i < 3; i++ {
+		fi, err := fsys.Stat("index.html")
+		if err != nil || fi.Size() != int64(len("<h1>secret project</h1>")) {
+			t.Fatalf("Stat returned %v, %v.", fi, err)
+		}
+	}
+	if cc.decrypted != 1 {
+		t.Errorf("Stat decrypted the file %d times.", cc.decrypted)
+	}
+
+	// A changed file is decrypted again.
+	enc, _ := EncryptFile(c, "index.html", []byte("changed"))
+	ioutil.WriteFile(filepath.Join(dir, "index.html"), enc, 0644)
+	if fi, err := fsys.Stat("index.html"); err != nil || fi.Size() != 7 {
+		t.Errorf("Stat of a changed file returned %v, %v.", fi, err)
+	}
+}
+
+func TestSwap(t *testing.T) {
+	dir, c := encrypted(t, nil)
+	a, b := filepath.Join(dir, "index.html"), filepath.Join(dir, "css", "site.css")
+	da, _ := ioutil.ReadFile(a)
+	db, _ := ioutil.ReadFile(b)
+	ioutil.WriteFile(a, db, 0644)
+	ioutil.WriteFile(b, da, 0644)
+	fsys := New(os.DirFS(dir), c, nil)
+	if _, err := fsys.ReadFile("index.html"); err == nil {
+		t.Errorf("Swapped files decrypt.")
+	}
+}
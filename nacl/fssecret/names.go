@@ -0,0 +1,96 @@
+package fssecret
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/andmarios/crypto/nacl/adsecret"
+	"github.com/andmarios/crypto/nacl/sivsecret"
+)
+
+// sivNames encrypts names with sivsecret, bound to their directory.
+type sivNames struct {
+	c *adsecret.ADSecret
+}
+
+// SIVNames returns a NameCipher that encrypts names deterministically with c
+// and encodes them with unpadded base64url. Names are bound to the path of
+// their directory, so equal names in different directories are encrypted
+// differently and files can not be moved between directories. An encrypted
+// name is 76 bytes longer than the plaintext before encoding, which limits
+// plaintext names to about 115 bytes on most filesystems.
+func SIVNames(c *sivsecret.SIVSecret) NameCipher {
+	return sivNames{adsecret.New(c)}
+}
+
+func (n sivNames) EncryptName(dir, name string) (string, error) {
+	ct, err := n.c.Encrypt([]byte(name), adsecret.Join("fssecret", dir))
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(ct), nil
+}
+
+func (n sivNames) DecryptName(dir, name string) (string, error) {
+	ct, err := base64.RawURLEncoding.DecodeString(name)
+	if err != nil {
+		return "", err
+	}
+	pt, err := n.c.Decrypt(ct, adsecret.Join("fssecret", dir))
+	if err != nil {
+		return "", err
+	}
+	if !fs.ValidPath(string(pt)) || path.Base(string(pt)) != string(pt) {
+		return "", errors.New("invalid file name")
+	}
+	return string(pt), nil
+}
+
+// EncryptDir encrypts the regular files of src with c into the directory
+// dst, which an FS with the same c and names can then serve. If names is
+// nil, file names are kept.
+func EncryptDir(dst string, src fs.FS, c adsecret.Cipher, names NameCipher) error {
+	stored := &FS{names: names}
+	return fs.WalkDir(src, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		sname, err := stored.stored(p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, filepath.FromSlash(sname))
+		if sh, ok := names.(Shortener); ok && p != "." {
+			_, encrypted, err := stored.component(path.Dir(p), path.Base(p))
+			if err != nil {
+				return err
+			}
+			if sidecar, ok := sh.Sidecar(path.Base(sname)); ok && encrypted != "" {
+				if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+					return err
+				}
+				if err = os.WriteFile(filepath.Join(filepath.Dir(target), sidecar), []byte(encrypted), 0644); err != nil {
+					return err
+				}
+			}
+		}
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		data, err := fs.ReadFile(src, p)
+		if err != nil {
+			return err
+		}
+		if data, err = EncryptFile(c, p, data); err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+}
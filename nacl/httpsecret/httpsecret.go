@@ -0,0 +1,278 @@
+/*
+Package httpsecret encrypts HTTP request and response bodies with padsecret
+or saltsecret.
+
+It provides an http.Handler middleware for servers and an http.RoundTripper
+for clients. A client that encrypts the request body sets the Secret-Encoding
+header to "nacl" and always sends Accept-Secret-Encoding, so that the server
+knows it may encrypt the response. The server decrypts the request body
+before passing the request to the wrapped handler and encrypts the response
+body the handler writes.
+
+The method, the path, the query and a configurable set of request headers
+are bound to the request body as associated data (see adsecret), so an
+encrypted body can not be replayed against another endpoint. The response
+body is additionally bound to the status code.
+
+If the server can not decrypt a request body, it replies with
+StatusDecryptFailed and a plain text error, without calling the wrapped handler.
+
+By default encryption is required both ways: the server refuses requests
+with a plaintext body or without Accept-Secret-Encoding, and the client
+refuses plaintext responses, so that someone on the path can not strip the
+encryption or inject plaintext. Responses without a body (to HEAD requests,
+or with a status that does not allow one) are not encrypted. Set Required to
+false to let plaintext through, for example while migrating.
+
+Encrypted bodies are read in full before they are decrypted, so their size is
+limited by MaxBodySize: the server replies to larger requests with 413
+Request Entity Too Large, and the client refuses larger responses.
+*/
+package httpsecret
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/andmarios/crypto/nacl/adsecret"
+)
+
+// Headers used to negotiate the body encryption.
+const (
+	EncodingHeader       = "Secret-Encoding"
+	AcceptEncodingHeader = "Accept-Secret-Encoding"
+	Encoding             = "nacl"
+)
+
+// DefaultMaxBodySize is the MaxBodySize of a new HTTPSecret.
+const DefaultMaxBodySize = 10 << 20
+
+// StatusDecryptFailed is the status the Handler replies with when it can
+// not decrypt a request body, or when a request is not encrypted and
+// encryption is required.
+const StatusDecryptFailed = http.StatusBadRequest
+
+// An HTTPSecret holds the cipher and the request headers bound to messages.
+// Required, true unless changed, makes the Handler and the Transport refuse
+// plaintext bodies. MaxBodySize is the largest encrypted body, in bytes, the
+// Handler and the Transport read; 0 means no limit.
+type HTTPSecret struct {
+	c           *adsecret.ADSecret
+	headers     []string
+	Required    bool
+	MaxBodySize int64
+}
+
+// New creates a new HTTPSecret instance. c is the cipher used for the bodies,
+// usually a padsecret.PadSecret or saltsecret.SaltSecret. headers are the names
+// of the request headers that are bound, along the method and path, to the
+// request and response bodies. Both sides should use the same headers.
+func New(c adsecret.Cipher, headers ...string) *HTTPSecret {
+	canonical := make([]string, len(headers))
+	for i, h := range headers {
+		canonical[i] = http.CanonicalHeaderKey(h)
+	}
+	return &HTTPSecret{adsecret.New(c), canonical, true, DefaultMaxBodySize}
+}
+
+// requestAD returns the associated data of a request body.
+func (s HTTPSecret) requestAD(r *http.Request) []byte {
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	fields := []string{"request", r.Method, path, r.URL.RawQuery}
+	for _, h := range s.headers {
+		fields = append(fields, h)
+		fields = append(fields, r.Header.Values(h)...)
+		fields = append(fields, "")
+	}
+	return adsecret.Join(fields...)
+}
+
+// responseAD returns the associated data of a response body.
+func (s HTTPSecret) responseAD(r *http.Request, status int) []byte {
+	return adsecret.Join("response", strconv.Itoa(status), string(s.requestAD(r)))
+}
+
+// Handler returns an http.Handler that decrypts encrypted request bodies, calls h,
+// and encrypts the response body if the client accepts it.
+func (s *HTTPSecret) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Required && (r.Header.Get(AcceptEncodingHeader) != Encoding ||
+			(r.Header.Get(EncodingHeader) != Encoding && r.ContentLength != 0)) {
+			http.Error(w, "request is not encrypted", StatusDecryptFailed)
+			return
+		}
+		if r.Header.Get(EncodingHeader) == Encoding {
+			if s.MaxBodySize > 0 {
+				r.Body = http.MaxBytesReader(w, r.Body, s.MaxBodySize)
+			}
+			body, err := io.ReadAll(r.Body)
+			r.Body.Close()
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "request body is too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				http.Error(w, "could not read request body", http.StatusBadRequest)
+				return
+			}
+			msg, err := s.c.Decrypt(body, s.requestAD(r))
+			if err != nil {
+				http.Error(w, "could not decrypt request body", StatusDecryptFailed)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(msg))
+			r.ContentLength = int64(len(msg))
+			r.Header.Del(EncodingHeader)
+			r.Header.Del("Content-Length")
+		}
+
+		if r.Header.Get(AcceptEncodingHeader) != Encoding || r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		rw := &responseWriter{header: make(http.Header)}
+		h.ServeHTTP(rw, r)
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+
+		header := w.Header()
+		for k, v := range rw.header {
+			header[k] = v
+		}
+		header.Add("Vary", AcceptEncodingHeader)
+		if !bodyAllowed(rw.status) {
+			w.WriteHeader(rw.status)
+			return
+		}
+
+		out, err := s.c.Encrypt(rw.body.Bytes(), s.responseAD(r, rw.status))
+		if err != nil {
+			for k := range header {
+				delete(header, k)
+			}
+			http.Error(w, "could not encrypt response body", http.StatusInternalServerError)
+			return
+		}
+		header.Set(EncodingHeader, Encoding)
+		header.Set("Content-Length", strconv.Itoa(len(out)))
+		w.WriteHeader(rw.status)
+		w.Write(out)
+	})
+}
+
+// bodyAllowed reports whether a response with the given status may have a body.
+func bodyAllowed(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// A responseWriter buffers the response of the wrapped handler, so it can
+// be encrypted as a whole.
+type responseWriter struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(p)
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Transport returns an http.RoundTripper that encrypts request bodies and decrypts
+// encrypted response bodies before returning them. If base is nil,
+// http.DefaultTransport is used.
+func (s *HTTPSecret) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{s, base}
+}
+
+type transport struct {
+	s    *HTTPSecret
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		out, err := t.s.c.Encrypt(body, t.s.requestAD(req))
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(out))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(out)), nil
+		}
+		req.ContentLength = int64(len(out))
+		req.Header.Set(EncodingHeader, Encoding)
+	}
+	req.Header.Set(AcceptEncodingHeader, Encoding)
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.Get(EncodingHeader) != Encoding {
+		if t.s.Required && req.Method != http.MethodHead && bodyAllowed(resp.StatusCode) {
+			resp.Body.Close()
+			return nil, errors.New("response is not encrypted (" + resp.Status + ")")
+		}
+		return resp, nil
+	}
+
+	var r io.Reader = resp.Body
+	if t.s.MaxBodySize > 0 {
+		r = io.LimitReader(resp.Body, t.s.MaxBodySize+1)
+	}
+	body, err := io.ReadAll(r)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if t.s.MaxBodySize > 0 && int64(len(body)) > t.s.MaxBodySize {
+		return nil, errors.New("response body is larger than " + strconv.FormatInt(t.s.MaxBodySize, 10) + " bytes")
+	}
+	msg, err := t.s.c.Decrypt(body, t.s.responseAD(req, resp.StatusCode))
+	if err != nil {
+		return nil, errors.New("could not decrypt response body: " + err.Error())
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(msg))
+	resp.ContentLength = int64(len(msg))
+	resp.Header.Del(EncodingHeader)
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
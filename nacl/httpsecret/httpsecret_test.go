@@ -0,0 +1,262 @@
+package httpsecret
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andmarios/crypto/nacl/padsecret"
+)
+
+const pad = "qwertyuiopasdfghjklzxcvbnm123456"
+
+func newSecret(t *testing.T, key string) *HTTPSecret {
+	c, err := padsecret.New(key, pad, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(c, "X-Tenant")
+}
+
+// echo replies with the method, the path, the X-Tenant header and the request body.
+var echo = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(r.Method + " " + r.URL.Path + " " + r.Header.Get("X-Tenant") + " " + string(body)))
+})
+
+func TestRoundTrip(t *testing.T) {
+	s := newSecret(t, "qwerty")
+	srv := httptest.NewServer(s.Handler(echo))
+	defer srv.Close()
+
+	client := &http.Client{Transport: s.Transport(nil)}
+	req, _ := http.NewRequest("POST", srv.URL+"/users", strings.NewReader(`{"name":"alice"}`))
+	req.Header.Set("X-Tenant", "acme")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("Status is %d, expected %d.", resp.StatusCode, http.StatusCreated)
+	}
+	if expected := `POST /users acme {"name":"alice"}`; string(body) != expected {
+		t.Errorf("Response body is '%s', expected '%s'.", body, expected)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Response Content-Type was not preserved.")
+	}
+}
+
+func TestEncryptedOnTheWire(t *testing.T) {
+	s := newSecret(t, "qwerty")
+	var wire []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wire, _ = io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(wire))
+		s.Handler(echo).ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/users", "application/json", strings.NewReader("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != StatusDecryptFailed {
+		t.Errorf("Plain request got status %d, expected %d.", resp.StatusCode, StatusDecryptFailed)
+	}
+
+	s.Required = false
+	resp, err = http.Post(srv.URL+"/users", "application/json", strings.NewReader("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "POST /users  secret" || resp.Header.Get(EncodingHeader) != "" {
+		t.Errorf("Plain request got unexpected response '%s'.", body)
+	}
+	s.Required = true
+
+	client := &http.Client{Transport: s.Transport(nil)}
+	resp, err = client.Post(srv.URL+"/users", "application/json", strings.NewReader("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if bytes.Contains(wire, []byte("secret")) {
+		t.Errorf("Request body was sent in plaintext.")
+	}
+}
+
+func TestDecryptFailure(t *testing.T) {
+	server := newSecret(t, "qwerty")
+	called := false
+	srv := httptest.NewServer(server.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})))
+	defer srv.Close()
+
+	// The error reply is not encrypted; a client that requires encryption
+	// refuses it.
+	wrong := newSecret(t, "azerty")
+	client := &http.Client{Transport: wrong.Transport(nil)}
+	if _, err := client.Post(srv.URL+"/users", "application/json", strings.NewReader("secret")); err == nil {
+		t.Errorf("Plaintext error reply was accepted.")
+	}
+	wrong.Required = false
+	resp, err := client.Post(srv.URL+"/users", "application/json", strings.NewReader("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != StatusDecryptFailed {
+		t.Errorf("Status for wrong key is %d, expected %d.", resp.StatusCode, StatusDecryptFailed)
+	}
+	if called {
+		t.Errorf("Handler was called with an undecryptable body.")
+	}
+}
+
+func TestBoundToRequest(t *testing.T) {
+	s := newSecret(t, "qwerty")
+	srv := httptest.NewServer(s.Handler(echo))
+	defer srv.Close()
+
+	// Encrypt a body for /users and replay it against /admin.
+	var captured []byte
+	capture := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		captured, _ = io.ReadAll(req.Body)
+		return &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: http.NoBody}, nil
+	})
+	req, _ := http.NewRequest("POST", srv.URL+"/users?id=1", strings.NewReader("secret"))
+	req.Header.Set("X-Tenant", "acme")
+	c := newSecret(t, "qwerty")
+	c.Required = false
+	if _, err := c.Transport(capture).RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct{ method, path, tenant string }{
+		{"POST", "/admin?id=1", "acme"},
+		{"PUT", "/users?id=1", "acme"},
+		{"POST", "/users?id=2", "acme"},
+		{"POST", "/users?id=1", "evil"},
+	} {
+		req, _ := http.NewRequest(tc.method, srv.URL+tc.path, bytes.NewReader(captured))
+		req.Header.Set(EncodingHeader, Encoding)
+		req.Header.Set(AcceptEncodingHeader, Encoding)
+		req.Header.Set("X-Tenant", tc.tenant)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != StatusDecryptFailed {
+			t.Errorf("Replayed body accepted for %s %s (tenant %s).", tc.method, tc.path, tc.tenant)
+		}
+	}
+}
+
+func TestStripped(t *testing.T) {
+	s := newSecret(t, "qwerty")
+	// A server that answers in plaintext, like one on the path would.
+	srv := httptest.NewServer(echo)
+	defer srv.Close()
+
+	client := &http.Client{Transport: s.Transport(nil)}
+	if _, err := client.Post(srv.URL+"/users", "text/plain", strings.NewReader("secret")); err == nil {
+		t.Errorf("Plaintext response was accepted.")
+	}
+	resp, err := client.Head(srv.URL + "/users")
+	if err != nil {
+		t.Errorf("Response to HEAD was refused: %v", err)
+	} else {
+		resp.Body.Close()
+	}
+}
+
+func TestTamperedResponse(t *testing.T) {
+	s := newSecret(t, "qwerty")
+	srv := httptest.NewServer(s.Handler(echo))
+	defer srv.Close()
+
+	tamper := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.StatusCode = http.StatusOK
+		return resp, nil
+	})
+	client := &http.Client{Transport: s.Transport(tamper)}
+	if _, err := client.Post(srv.URL+"/users", "text/plain", strings.NewReader("secret")); err == nil {
+		t.Errorf("Response with modified status was accepted.")
+	}
+}
+
+func TestNoContent(t *testing.T) {
+	s := newSecret(t, "qwerty")
+	srv := httptest.NewServer(s.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	defer srv.Close()
+
+	client := &http.Client{Transport: s.Transport(nil)}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Status is %d, expected %d.", resp.StatusCode, http.StatusNoContent)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestMaxBodySize(t *testing.T) {
+	server, client := newSecret(t, "qwerty"), newSecret(t, "qwerty")
+	server.MaxBodySize = 1000
+	srv := httptest.NewServer(server.Handler(echo))
+	defer srv.Close()
+
+	// The bodies are compressed, so they have to be random.
+	random := func(n int) io.Reader {
+		b := make([]byte, n)
+		rand.Read(b)
+		return bytes.NewReader(b)
+	}
+	c := &http.Client{Transport: client.Transport(nil)}
+	// The error reply is not encrypted, the Transport reports its status.
+	_, err := c.Post(srv.URL+"/users", "text/plain", random(2000))
+	if err == nil || !strings.Contains(err.Error(), "413") {
+		t.Errorf("Request larger than MaxBodySize returned %v.", err)
+	}
+	resp, err := c.Post(srv.URL+"/users", "text/plain", random(500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("Status is %d, expected %d.", resp.StatusCode, http.StatusCreated)
+	}
+
+	client.MaxBodySize = 100
+	if _, err = c.Post(srv.URL+"/users", "text/plain", random(500)); err == nil {
+		t.Errorf("Response larger than MaxBodySize was accepted.")
+	}
+}
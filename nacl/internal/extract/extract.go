@@ -0,0 +1,101 @@
+/*
+Package extract holds the helpers tarsecret and backupsecret share to write
+the files of an archive or snapshot under a directory without escaping it.
+*/
+package extract
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Selected reports whether name is one of paths or under one of them, and
+// marks the path as found. Every name is selected if paths is empty.
+func Selected(name string, paths []string, found []bool) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for i, p := range paths {
+		p = strings.Trim(path.Clean(filepath.ToSlash(p)), "/")
+		if name == p || strings.HasPrefix(name, p+"/") {
+			found[i] = true
+			return true
+		}
+	}
+	return false
+}
+
+// SafePath returns the path of the slash-separated name under dir, refusing
+// names that would escape it, directly or through a symbolic link. what
+// names the source in errors, like "archive".
+func SafePath(dir, name, what string) (string, error) {
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || clean == "." {
+		return "", errors.New(what + " holds unsafe path " + name)
+	}
+	target := dir
+	parts := strings.Split(clean, "/")
+	for _, p := range parts[:len(parts)-1] {
+		target = filepath.Join(target, p)
+		if fi, err := os.Lstat(target); err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+			return "", errors.New(what + " path " + name + " goes through a symbolic link")
+		}
+	}
+	return filepath.Join(target, parts[len(parts)-1]), nil
+}
+
+// A Dir is a directory whose mode and modification time are set once it is
+// filled, since writing files into it changes them.
+type Dir struct {
+	Path    string
+	Mode    fs.FileMode
+	ModTime time.Time
+}
+
+// SetDirs sets the modes and times of dirs, deepest first.
+func SetDirs(dirs []Dir) error {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].Path, dirs[i].Mode.Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(dirs[i].Path, time.Now(), dirs[i].ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeLink removes target if it is a symbolic link, which an earlier entry
+// may have created to redirect writes outside of the directory.
+func removeLink(target string) error {
+	if fi, err := os.Lstat(target); err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+		return os.Remove(target)
+	}
+	return nil
+}
+
+// Create creates or truncates the regular file target, and its parent
+// directories. A symbolic link at target is replaced, not followed.
+func Create(target string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return nil, err
+	}
+	if err := removeLink(target); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+}
+
+// Mkdir creates the directory target and its parents. A symbolic link at
+// target is replaced, not followed.
+func Mkdir(target string) error {
+	if err := removeLink(target); err != nil {
+		return err
+	}
+	return os.MkdirAll(target, 0700)
+}
@@ -0,0 +1,36 @@
+package extract
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafePath(t *testing.T) {
+	dir := t.TempDir()
+	os.Symlink(os.TempDir(), filepath.Join(dir, "out"))
+	for _, name := range []string{"../escape", "a/../../escape", "/etc/passwd", "out/file"} {
+		if _, err := SafePath(dir, name, "archive"); err == nil {
+			t.Errorf("SafePath() accepts '%s'.", name)
+		}
+	}
+	if p, err := SafePath(dir, "a/./b", "archive"); err != nil || p != filepath.Join(dir, "a", "b") {
+		t.Errorf("SafePath() returned '%s', %v.", p, err)
+	}
+}
+
+func TestSelected(t *testing.T) {
+	paths := []string{"docs/", "a.txt"}
+	found := make([]bool, len(paths))
+	for name, want := range map[string]bool{"docs": true, "docs/b.txt": true, "docsx": false, "b.txt": false} {
+		if Selected(name, paths, found) != want {
+			t.Errorf("Selected(%s) is not %v.", name, want)
+		}
+	}
+	if !found[0] || found[1] {
+		t.Errorf("Found %v.", found)
+	}
+	if !Selected("anything", nil, nil) {
+		t.Errorf("No paths do not select everything.")
+	}
+}
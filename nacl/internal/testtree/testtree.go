@@ -0,0 +1,49 @@
+/*
+Package testtree creates the directory trees that the tests of tarsecret and
+backupsecret archive and restore.
+*/
+package testtree
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ModTime is the modification time of the files and directories New creates.
+var ModTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// New creates a temporary directory holding files, keyed by slash-separated
+// path, with mode 0640 and ModTime. The file small gets mode 0600 and a
+// symbolic link to it, named "link", is added to the top directory.
+func New(t testing.TB, files map[string][]byte, small string) string {
+	dir := t.TempDir()
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, data, 0640); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(p, ModTime, ModTime)
+	}
+	var dirs []string
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() && p != dir {
+			dirs = append(dirs, p)
+		}
+		return err
+	})
+	// Deepest first, since setting the time of a directory does not change
+	// its parent.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Chtimes(dirs[i], ModTime, ModTime)
+	}
+	os.Chmod(filepath.Join(dir, filepath.FromSlash(small)), 0600)
+	if err := os.Symlink(small, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
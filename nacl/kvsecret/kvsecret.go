@@ -0,0 +1,393 @@
+/*
+Package kvsecret implements an embedded key-value store that keeps its
+records encrypted in a single append-only file.
+
+Every value is encrypted, usually with padsecret, with its key bound as
+associated data, so a value can not be moved to another key. Records are
+appended to the file and indexed in memory when the store is opened; Get
+reads and decrypts a single record. Overwritten and deleted records stay in
+the file until Compact rewrites it.
+
+Each record ends with a CRC-32 checksum. If the process crashes in the middle
+of a write, Open drops the torn final record. Damage anywhere else is
+reported as an error.
+
+By default keys are stored in plaintext. A store opened with OpenBlind stores
+a blind index (see blindindex) of each key instead, hiding the key names too;
+such stores can not list their keys.
+
+Only the values are authenticated. Someone with write access to the file may
+still delete records or replace a value with an older one of the same key.
+The file should be used by a single Store at a time.
+*/
+package kvsecret
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/andmarios/crypto/nacl/adsecret"
+	"github.com/andmarios/crypto/nacl/blindindex"
+)
+
+// Header starts every store file. It is followed by a flags byte.
+const Header = "KVS1"
+
+const (
+	flagBlind byte = 0x01
+
+	opPut    byte = 1
+	opDelete byte = 2
+
+	// MaxKeySize is the maximum size of a key (or of its blind index).
+	MaxKeySize = 1<<16 - 1
+	// minBlindSize is the smallest blind index that keeps collisions unlikely.
+	minBlindSize = 16
+)
+
+// Errors returned by a Store.
+var (
+	ErrNotFound = errors.New("key not found")
+	ErrBlind    = errors.New("store with blind indexed keys can not list keys")
+	ErrCorrupt  = errors.New("store file is corrupt")
+)
+
+// A location points to the encrypted value of a record in the file.
+type location struct {
+	off  int64
+	size int
+}
+
+// A Store is an encrypted key-value store. It is safe for concurrent use.
+type Store struct {
+	mu    sync.Mutex
+	path  string
+	f     *os.File
+	end   int64
+	c     *adsecret.ADSecret
+	blind *blindindex.BlindIndex
+	index map[string]location
+}
+
+// Open opens the store in the file path, creating it if needed. Values are
+// encrypted with c, usually a padsecret.PadSecret.
+func Open(path string, c adsecret.Cipher) (*Store, error) {
+	return open(path, c, nil)
+}
+
+// OpenBlind opens the store in the file path like Open, but stores the blind
+// indexes of the keys computed by idx instead of the keys. idx should produce
+// indexes of at least 16 bytes.
+func OpenBlind(path string, c adsecret.Cipher, idx *blindindex.BlindIndex) (*Store, error) {
+	if len(idx.Index("kvsecret key", nil)) < minBlindSize {
+		return nil, errors.New("kvsecret blind indexes should be at least 16 bytes")
+	}
+	return open(path, c, idx)
+}
+
+func open(path string, c adsecret.Cipher, idx *blindindex.BlindIndex) (*Store, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{path: path, f: f, c: adsecret.New(c), blind: idx}
+	if err = s.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) flags() byte {
+	if s.blind != nil {
+		return flagBlind
+	}
+	return 0
+}
+
+// load builds the index from the file, writing the header of new files and
+// truncating a torn final record.
+func (s *Store) load() error {
+	fi, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	if size == 0 {
+		if _, err = s.f.Write(append([]byte(Header), s.flags())); err != nil {
+			return err
+		}
+		s.end = int64(len(Header) + 1)
+		s.index = make(map[string]location)
+		return s.f.Sync()
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(s.f, 0, size))
+	head := make([]byte, len(Header)+1)
+	if _, err = io.ReadFull(r, head); err != nil || string(head[:len(Header)]) != Header {
+		return errors.New("not a kvsecret store file")
+	}
+	if head[len(Header)] != s.flags() {
+		return errors.New("kvsecret store was created with blind indexed keys off/on, open it the same way")
+	}
+
+	s.index = make(map[string]location)
+	off := int64(len(head))
+	for off < size {
+		op, key, value, n, err := readRecord(r, size-off)
+		if err == io.ErrUnexpectedEOF || (err == ErrCorrupt && off+n == size) {
+			// A torn final write; drop it. A damaged length can look
+			// the same, so make sure no record follows.
+			if recordAfter(s.f, off+1, size) {
+				return ErrCorrupt
+			}
+			if err = s.f.Truncate(off); err != nil {
+				return err
+			}
+			if err = s.f.Sync(); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch op {
+		case opPut:
+			s.index[string(key)] = location{off + n - int64(len(value)) - crc32.Size, len(value)}
+		case opDelete:
+			delete(s.index, string(key))
+		default:
+			return ErrCorrupt
+		}
+		off += n
+	}
+	s.end = off
+	return nil
+}
+
+// A record is:
+//
+//	length (4 bytes, of op to value) | op (1) | key length (2) | key | value | CRC-32 (4)
+//
+// readRecord reads a record of at most max bytes and returns its total size.
+// A record that does not fit returns io.ErrUnexpectedEOF.
+func readRecord(r io.Reader, max int64) (op byte, key, value []byte, n int64, err error) {
+	var hdr [7]byte
+	if _, err = io.ReadFull(r, hdr[:4]); err != nil {
+		return 0, nil, nil, 0, io.ErrUnexpectedEOF
+	}
+	length := int64(binary.BigEndian.Uint32(hdr[:4]))
+	n = 4 + length + crc32.Size
+	if n > max {
+		return 0, nil, nil, 0, io.ErrUnexpectedEOF
+	}
+	if length < 3 {
+		return 0, nil, nil, n, ErrCorrupt
+	}
+	body := make([]byte, length+crc32.Size)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return 0, nil, nil, 0, io.ErrUnexpectedEOF
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[:4])
+	crc.Write(body[:length])
+	if crc.Sum32() != binary.BigEndian.Uint32(body[length:]) {
+		return 0, nil, nil, n, ErrCorrupt
+	}
+	keyLen := int64(binary.BigEndian.Uint16(body[1:3]))
+	if 3+keyLen > length {
+		return 0, nil, nil, n, ErrCorrupt
+	}
+	return body[0], body[3 : 3+keyLen], body[3+keyLen : length], n, nil
+}
+
+// recordAfter reports whether a valid record starts anywhere in f between
+// off and size.
+func recordAfter(f io.ReaderAt, off, size int64) bool {
+	if off >= size {
+		return false
+	}
+	tail := make([]byte, size-off)
+	if _, err := f.ReadAt(tail, off); err != nil {
+		return false
+	}
+	for i := range tail {
+		rest := tail[i:]
+		if _, _, _, _, err := readRecord(bytes.NewReader(rest), int64(len(rest))); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeRecord(op byte, key, value []byte) []byte {
+	length := 3 + len(key) + len(value)
+	b := make([]byte, 0, 4+length+crc32.Size)
+	b = binary.BigEndian.AppendUint32(b, uint32(length))
+	b = append(b, op)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(key)))
+	b = append(b, key...)
+	b = append(b, value...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+}
+
+// id returns the key as stored in the file.
+func (s *Store) id(key string) []byte {
+	if s.blind != nil {
+		return s.blind.Index("kvsecret key", []byte(key))
+	}
+	return []byte(key)
+}
+
+// Get returns the value of key, or ErrNotFound.
+func (s *Store) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil, os.ErrClosed
+	}
+	loc, ok := s.index[string(s.id(key))]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	ct := make([]byte, loc.size)
+	if _, err := s.f.ReadAt(ct, loc.off); err != nil {
+		return nil, err
+	}
+	return s.c.Decrypt(ct, []byte(key))
+}
+
+// Put sets the value of key. The record is synced to disk before Put returns.
+func (s *Store) Put(key string, value []byte) error {
+	id := s.id(key)
+	if len(id) > MaxKeySize {
+		return errors.New("kvsecret key too long")
+	}
+	ct, err := s.c.Encrypt(value, []byte(key))
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.append(opPut, id, ct)
+}
+
+// Delete removes key. Deleting a key that does not exist is not an error.
+func (s *Store) Delete(key string) error {
+	id := s.id(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.index[string(id)]; !ok {
+		return nil
+	}
+	return s.append(opDelete, id, nil)
+}
+
+func (s *Store) append(op byte, id, ct []byte) error {
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	rec := encodeRecord(op, id, ct)
+	if _, err := s.f.WriteAt(rec, s.end); err != nil {
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	if op == opPut {
+		s.index[string(id)] = location{s.end + int64(len(rec)-len(ct)-crc32.Size), len(ct)}
+	} else {
+		delete(s.index, string(id))
+	}
+	s.end += int64(len(rec))
+	return nil
+}
+
+// Len returns the number of keys in the store.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.index)
+}
+
+// Keys returns the keys in the store, sorted. Stores opened with OpenBlind
+// return ErrBlind.
+func (s *Store) Keys() ([]string, error) {
+	if s.blind != nil {
+		return nil, ErrBlind
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.index))
+	for k := range s.index {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Compact rewrites the file with only the live records. The new file
+// replaces the old one atomically.
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+
+	tmp := s.path + ".compact"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	var b bytes.Buffer
+	b.WriteString(Header)
+	b.WriteByte(s.flags())
+	index := make(map[string]location, len(s.index))
+	for id, loc := range s.index {
+		ct := make([]byte, loc.size)
+		if _, err = s.f.ReadAt(ct, loc.off); err != nil {
+			f.Close()
+			return err
+		}
+		rec := encodeRecord(opPut, []byte(id), ct)
+		index[id] = location{int64(b.Len() + len(rec) - len(ct) - crc32.Size), len(ct)}
+		b.Write(rec)
+	}
+	if _, err = f.Write(b.Bytes()); err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	s.f.Close()
+	s.f, s.index, s.end = f, index, int64(b.Len())
+	return nil
+}
+
+// Close closes the store file.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
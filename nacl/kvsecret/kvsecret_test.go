@@ -0,0 +1,210 @@
+package kvsecret
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/andmarios/crypto/nacl/blindindex"
+	"github.com/andmarios/crypto/nacl/padsecret"
+)
+
+const pad = "qwertyuiopasdfghjklzxcvbnm123456"
+
+func TestPackage(t *testing.T) {
+	c, _ := padsecret.New("qwerty", pad, false)
+	path := filepath.Join(t.TempDir(), "store")
+	s, err := Open(path, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err = s.Put("key"+strconv.Itoa(i%10), []byte("value"+strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Delete("key0"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Delete("missing"); err != nil {
+		t.Errorf("Delete() of a missing key returned %v.", err)
+	}
+	if v, err := s.Get("key3"); err != nil || string(v) != "value93" {
+		t.Errorf("Get() returned '%s', %v, expected 'value93'.", v, err)
+	}
+	if _, err = s.Get("key0"); err != ErrNotFound {
+		t.Errorf("Get() of a deleted key returned %v.", err)
+	}
+	s.Close()
+
+	b, _ := ioutil.ReadFile(path)
+	if bytes.Contains(b, []byte("value")) {
+		t.Errorf("Store file contains plaintext values.")
+	}
+
+	s, err = Open(path, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := s.Keys()
+	if err != nil || len(keys) != 9 || keys[0] != "key1" || s.Len() != 9 {
+		t.Errorf("Keys() returned %v, %v after reopening.", keys, err)
+	}
+	before, _ := os.Stat(path)
+	if err = s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Errorf("Compact() did not shrink the file: %d >= %d bytes.", after.Size(), before.Size())
+	}
+	if err = s.Put("new", []byte("after compaction")); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = Open(path, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{"key9": "value99", "new": "after compaction"} {
+		if got, err := s.Get(k); err != nil || string(got) != v {
+			t.Errorf("Get(%s) returned '%s', %v after compaction, expected '%s'.", k, got, err, v)
+		}
+	}
+
+	s.Close()
+	wrong, _ := padsecret.New("other", pad, false)
+	other, err := Open(path, wrong)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err = other.Get("key9"); err == nil {
+		t.Errorf("Get() succeeds with the wrong key.")
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	c, _ := padsecret.New("qwerty", pad, false)
+	path := filepath.Join(t.TempDir(), "store")
+	s, err := Open(path, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put("a", []byte("first"))
+	s.Put("b", []byte("second"))
+	s.Close()
+	good, _ := ioutil.ReadFile(path)
+
+	// Every possible torn write of a third record.
+	rec := encodeRecord(opPut, []byte("c"), []byte("some ciphertext"))
+	for i := 1; i < len(rec); i++ {
+		ioutil.WriteFile(path, append(append([]byte{}, good...), rec[:i]...), 0600)
+		s, err := Open(path, c)
+		if err != nil {
+			t.Fatalf("Open() failed with %d bytes of a torn record: %v", i, err)
+		}
+		if v, err := s.Get("b"); err != nil || string(v) != "second" || s.Len() != 2 {
+			t.Errorf("Store lost records after recovering from %d torn bytes.", i)
+		}
+		s.Close()
+		if b, _ := ioutil.ReadFile(path); !bytes.Equal(b, good) {
+			t.Errorf("Open() did not truncate %d torn bytes.", i)
+		}
+	}
+
+	// A torn final record whose length is intact but its data is garbled.
+	torn := append([]byte{}, rec...)
+	torn[len(torn)-6] ^= 0xff
+	ioutil.WriteFile(path, append(append([]byte{}, good...), torn...), 0600)
+	if s, err = Open(path, c); err != nil || s.Len() != 2 {
+		t.Errorf("Open() did not recover from a garbled final record: %v", err)
+	} else {
+		s.Close()
+	}
+
+	// Damage before the final record is not recoverable.
+	bad := append([]byte{}, good...)
+	bad[len(Header)+8] ^= 0xff
+	ioutil.WriteFile(path, bad, 0600)
+	if _, err = Open(path, c); err != ErrCorrupt {
+		t.Errorf("Open() returned %v for a damaged record, expected ErrCorrupt.", err)
+	}
+
+	// A damaged length in the middle looks like a torn record, but the
+	// records after it show it is not.
+	path = filepath.Join(t.TempDir(), "store")
+	s, _ = Open(path, c)
+	s.Put("a", []byte("first"))
+	s.Put("b", []byte("second"))
+	s.Put("c", []byte("third"))
+	s.Close()
+	good, _ = ioutil.ReadFile(path)
+	bad = append([]byte{}, good...)
+	bad[len(Header)+1] = 0x7f
+	ioutil.WriteFile(path, bad, 0600)
+	if _, err = Open(path, c); err != ErrCorrupt {
+		t.Errorf("Open() returned %v for a damaged length, expected ErrCorrupt.", err)
+	}
+	if b, _ := ioutil.ReadFile(path); !bytes.Equal(b, bad) {
+		t.Errorf("Open() truncated the records after a damaged length.")
+	}
+
+	ioutil.WriteFile(path, []byte("not a store"), 0600)
+	if _, err = Open(path, c); err == nil {
+		t.Errorf("Open() accepts files that are not stores.")
+	}
+}
+
+func TestBlind(t *testing.T) {
+	c, _ := padsecret.New("qwerty", pad, false)
+	path := filepath.Join(t.TempDir(), "store")
+	small, _ := blindindex.New("index key", pad, 8)
+	if _, err := OpenBlind(path, c, small); err == nil {
+		t.Errorf("OpenBlind() accepts 8 bytes indexes.")
+	}
+
+	idx, err := blindindex.New("index key", pad, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenBlind(path, c, idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put("api-token", []byte("secret"))
+	s.Put("db-password", []byte("hunter2"))
+	s.Delete("api-token")
+	if _, err = s.Keys(); err != ErrBlind {
+		t.Errorf("Keys() returned %v, expected ErrBlind.", err)
+	}
+	s.Close()
+
+	b, _ := ioutil.ReadFile(path)
+	if bytes.Contains(b, []byte("password")) || bytes.Contains(b, []byte("token")) {
+		t.Errorf("Blind store file contains key names.")
+	}
+	if _, err = Open(path, c); err == nil {
+		t.Errorf("Open() opens a blind store.")
+	}
+
+	s, err = OpenBlind(path, c, idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if v, err := s.Get("db-password"); err != nil || string(v) != "hunter2" {
+		t.Errorf("Get() returned '%s', %v.", v, err)
+	}
+	if _, err = s.Get("api-token"); err != ErrNotFound {
+		t.Errorf("Get() of a deleted key returned %v.", err)
+	}
+	if keys := reflect.ValueOf(s.index).MapKeys(); len(keys) != 1 || len(keys[0].String()) != 16 {
+		t.Errorf("Unexpected index %v.", keys)
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Package namesecret encrypts file names, so that trees encrypted file by file
+do not leak project and customer names through their paths.
+
+Every path component is encrypted deterministically, in a synthetic IV (SIV)
+construction like sivsecret's: a keyed BLAKE2b digest of the directory ID and
+the padded name is both the authentication tag and the XSalsa20 nonce. The
+directory ID, a keyed digest of the plaintext path of the parent directory,
+is the tweak: equal names in different directories are encrypted
+differently, and a name does not decrypt in another directory. Names are
+padded to a multiple of 16 bytes, to hide their exact length.
+
+Encrypted names are encoded with unpadded base64url, or with lowercase
+base32 for case-insensitive filesystems. Names that would be longer than
+MaxLength once encrypted are stored under a hashed name instead, with the
+full encrypted name in a sidecar file; a NameSecret implements
+fssecret.NameCipher and fssecret.Shortener, so fssecret handles both.
+
+The keys are derived from the key of the content encryption, like
+sivsecret's subkeys, so the same key and pad can be used for both. Since
+directory IDs derive from paths, renaming a directory changes the encrypted
+names of everything below it.
+*/
+package namesecret
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/andmarios/crypto/nacl/adsecret"
+	"github.com/andmarios/crypto/nacl/padsecret"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/salsa20"
+)
+
+// Encoding selects how encrypted names are encoded.
+type Encoding int
+
+// Name encodings.
+const (
+	// Base64URL is unpadded base64url, the shortest.
+	Base64URL Encoding = iota
+	// Base32 is unpadded lowercase base32, for case-insensitive filesystems.
+	Base32
+)
+
+// DefaultMaxLength is the longest name most filesystems accept.
+const DefaultMaxLength = 255
+
+// Long names are stored as LongPrefix followed by a digest of the encrypted
+// name, and their encrypted name in a file with SidecarSuffix appended.
+const (
+	LongPrefix    = "namesecret.long."
+	SidecarSuffix = ".name"
+)
+
+const (
+	keySize   = 32
+	tagSize   = 16
+	nonceSize = 24
+	blockSize = 16
+)
+
+var base32Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ErrDecrypt is returned for names that were not encrypted with the same
+// key in the same directory.
+var ErrDecrypt = errors.New("could not decrypt name")
+
+// A NameSecret encrypts file names. MaxLength, DefaultMaxLength unless
+// changed, is the longest encrypted name Shorten leaves as is.
+type NameSecret struct {
+	macKey    []byte
+	dirKey    []byte
+	encKey    *[keySize]byte
+	enc       Encoding
+	MaxLength int
+}
+
+// New creates a new NameSecret instance. key and pad are combined like in
+// padsecret.New (pad should be at least 32 bytes).
+func New(key, pad string, enc Encoding) (*NameSecret, error) {
+	k, err := padsecret.Key(key, pad)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromKey(k, enc), nil
+}
+
+// NewFromKey creates a new NameSecret instance from a raw 32 bytes key.
+func NewFromKey(key *[32]byte, enc Encoding) *NameSecret {
+	encKey := new([keySize]byte)
+	copy(encKey[:], subkey(key, "namesecret encryption key"))
+	return &NameSecret{
+		macKey:    subkey(key, "namesecret mac key"),
+		dirKey:    subkey(key, "namesecret directory key"),
+		encKey:    encKey,
+		enc:       enc,
+		MaxLength: DefaultMaxLength,
+	}
+}
+
+func subkey(key *[32]byte, label string) []byte {
+	h, _ := blake2b.New256(key[:])
+	h.Write([]byte(label))
+	return h.Sum(nil)
+}
+
+// dirID returns the tweak of the directory at the plaintext path dir.
+func (n *NameSecret) dirID(dir string) []byte {
+	h, _ := blake2b.New(tagSize, n.dirKey)
+	h.Write([]byte(dir))
+	return h.Sum(nil)
+}
+
+func (n *NameSecret) tag(dir string, padded []byte) []byte {
+	h, _ := blake2b.New(tagSize, n.macKey)
+	h.Write(adsecret.Join(string(n.dirID(dir)), string(padded)))
+	return h.Sum(nil)
+}
+
+func nonce(tag []byte) []byte {
+	out := make([]byte, nonceSize)
+	copy(out, tag)
+	return out
+}
+
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\x00")
+}
+
+// EncryptName encrypts name, a single path component, for the directory
+// with the plaintext path dir ("." for the root).
+func (n *NameSecret) EncryptName(dir, name string) (string, error) {
+	if !validName(name) {
+		return "", errors.New("invalid file name " + name)
+	}
+	// Pad to a multiple of blockSize, PKCS#7 style.
+	p := blockSize - len(name)%blockSize
+	padded := append([]byte(name), bytes.Repeat([]byte{byte(p)}, p)...)
+
+	tag := n.tag(dir, padded)
+	out := make([]byte, tagSize+len(padded))
+	copy(out, tag)
+	salsa20.XORKeyStream(out[tagSize:], padded, nonce(tag), n.encKey)
+	return n.encode(out), nil
+}
+
+// DecryptName decrypts an encrypted name of the directory dir.
+func (n *NameSecret) DecryptName(dir, name string) (string, error) {
+	in, err := n.decode(name)
+	if err != nil || len(in) < tagSize+blockSize || (len(in)-tagSize)%blockSize != 0 {
+		return "", ErrDecrypt
+	}
+	tag := in[:tagSize]
+	padded := make([]byte, len(in)-tagSize)
+	salsa20.XORKeyStream(padded, in[tagSize:], nonce(tag), n.encKey)
+	if subtle.ConstantTimeCompare(tag, n.tag(dir, padded)) != 1 {
+		return "", ErrDecrypt
+	}
+	p := int(padded[len(padded)-1])
+	if p == 0 || p > blockSize {
+		return "", ErrDecrypt
+	}
+	out := string(padded[:len(padded)-p])
+	if !validName(out) {
+		return "", ErrDecrypt
+	}
+	return out, nil
+}
+
+func (n *NameSecret) encode(b []byte) string {
+	if n.enc == Base32 {
+		return strings.ToLower(base32Encoding.EncodeToString(b))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (n *NameSecret) decode(s string) ([]byte, error) {
+	if n.enc == Base32 {
+		return base32Encoding.DecodeString(strings.ToUpper(s))
+	}
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// Shorten returns the name a long encrypted name is stored under, made of a
+// digest of it, and the name of the sidecar file that holds the encrypted
+// name. ok is false if the encrypted name is not longer than MaxLength.
+func (n *NameSecret) Shorten(encrypted string) (stored, sidecar string, ok bool) {
+	if len(encrypted) <= n.MaxLength {
+		return "", "", false
+	}
+	sum := blake2b.Sum256([]byte(encrypted))
+	stored = LongPrefix + n.encode(sum[:])
+	return stored, stored + SidecarSuffix, true
+}
+
+// Sidecar returns the name of the sidecar file of a stored name, if it is a
+// shortened one.
+func (n *NameSecret) Sidecar(stored string) (string, bool) {
+	if !strings.HasPrefix(stored, LongPrefix) || strings.HasSuffix(stored, SidecarSuffix) {
+		return "", false
+	}
+	return stored + SidecarSuffix, true
+}
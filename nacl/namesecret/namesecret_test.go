@@ -0,0 +1,147 @@
+package namesecret
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/andmarios/crypto/nacl/fssecret"
+	"github.com/andmarios/crypto/nacl/padsecret"
+)
+
+var (
+	key = "qwerty"
+	pad = "qwertyuiopasdfghjklzxcvbnm123456"
+)
+
+func TestEncryptName(t *testing.T) {
+	for _, enc := range []Encoding{Base64URL, Base32} {
+		n, err := New(key, pad, enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"a", "acme-corp-contract.pdf", "exactly 16 bytes", ".hidden", "ünïcode ☃", strings.Repeat("x", 200)} {
+			e, err := n.EncryptName("customers/acme", name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(e, "acme") || strings.ContainsAny(e, "/.=+") {
+				t.Errorf("Encoding %d: unsafe or leaking name %s.", enc, e)
+			}
+			if enc == Base32 && strings.ToLower(e) != e {
+				t.Errorf("Base32 name %s is not lowercase.", e)
+			}
+			e2, _ := n.EncryptName("customers/acme", name)
+			if e != e2 {
+				t.Errorf("Encryption is not deterministic.")
+			}
+			d, err := n.DecryptName("customers/acme", e)
+			if err != nil || d != name {
+				t.Errorf("Encoding %d: %q decrypts to %q, %v.", enc, name, d, err)
+			}
+			if _, err = n.DecryptName("customers/other", e); err != ErrDecrypt {
+				t.Errorf("A name decrypts in another directory: %v.", err)
+			}
+		}
+	}
+
+	n, _ := New(key, pad, Base64URL)
+	// Names are padded to hide their length.
+	a, _ := n.EncryptName(".", "a")
+	b, _ := n.EncryptName(".", "abcdefghijklmno")
+	if len(a) != len(b) {
+		t.Errorf("Names of 1 and 15 bytes have different lengths.")
+	}
+	for _, bad := range []string{"", ".", "..", "a/b"} {
+		if _, err := n.EncryptName(".", bad); err == nil {
+			t.Errorf("EncryptName accepts %q.", bad)
+		}
+	}
+	other, _ := New("other", pad, Base64URL)
+	if _, err := other.DecryptName(".", a); err != ErrDecrypt {
+		t.Errorf("A name decrypts with another key.")
+	}
+	if _, err := n.DecryptName(".", "not-encrypted"); err != ErrDecrypt {
+		t.Errorf("Expected ErrDecrypt for a plain name, got %v.", err)
+	}
+}
+
+func TestShorten(t *testing.T) {
+	n, _ := New(key, pad, Base64URL)
+	short, _ := n.EncryptName(".", "short")
+	if _, _, ok := n.Shorten(short); ok {
+		t.Errorf("Short name was shortened.")
+	}
+	long, _ := n.EncryptName(".", strings.Repeat("x", 200))
+	stored, sidecar, ok := n.Shorten(long)
+	if !ok || len(stored) > DefaultMaxLength || sidecar != stored+SidecarSuffix {
+		t.Errorf("Shorten returned %s, %s, %v.", stored, sidecar, ok)
+	}
+	if s, ok := n.Sidecar(stored); !ok || s != sidecar {
+		t.Errorf("Sidecar returned %s, %v.", s, ok)
+	}
+	for _, name := range []string{short, sidecar} {
+		if _, ok := n.Sidecar(name); ok {
+			t.Errorf("%s is taken for a shortened name.", name)
+		}
+	}
+}
+
+func TestFS(t *testing.T) {
+	long := strings.Repeat("quarterly report of a customer with a long name ", 4) + ".txt"
+	src := fstest.MapFS{
+		"acme/contract.pdf":       {Data: []byte("contract")},
+		"acme/" + long:            {Data: []byte("long")},
+		"initech/notes/today.txt": {Data: []byte("notes")},
+	}
+	c, _ := padsecret.New(key, pad, false)
+	for _, enc := range []Encoding{Base64URL, Base32} {
+		n, _ := New(key, pad, enc)
+		dir := t.TempDir()
+		if err := fssecret.EncryptDir(dir, src, c, n); err != nil {
+			t.Fatal(err)
+		}
+		filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+			if strings.Contains(p, "acme") || strings.Contains(p, "report") || len(fi.Name()) > DefaultMaxLength {
+				t.Errorf("Stored path %s leaks names or is too long.", p)
+			}
+			return nil
+		})
+		fsys := fssecret.New(os.DirFS(dir), c, n)
+		if err := fstest.TestFS(fsys, "acme/contract.pdf", "acme/"+long, "initech/notes/today.txt"); err != nil {
+			t.Errorf("Encoding %d: %v", enc, err)
+		}
+		if b, err := fsys.ReadFile("acme/" + long); err != nil || string(b) != "long" {
+			t.Errorf("Encoding %d: long name reads %q, %v.", enc, b, err)
+		}
+	}
+}
+
+func TestSwappedSidecars(t *testing.T) {
+	src := fstest.MapFS{
+		strings.Repeat("a", 200): {Data: []byte("a")},
+		strings.Repeat("b", 200): {Data: []byte("b")},
+	}
+	c, _ := padsecret.New(key, pad, false)
+	n, _ := New(key, pad, Base64URL)
+	dir := t.TempDir()
+	if err := fssecret.EncryptDir(dir, src, c, n); err != nil {
+		t.Fatal(err)
+	}
+	sidecars, _ := filepath.Glob(filepath.Join(dir, "*"+SidecarSuffix))
+	if len(sidecars) != 2 {
+		t.Fatalf("Found sidecars %v.", sidecars)
+	}
+	a, _ := os.ReadFile(sidecars[0])
+	b, _ := os.ReadFile(sidecars[1])
+	os.WriteFile(sidecars[0], b, 0644)
+	os.WriteFile(sidecars[1], a, 0644)
+
+	// Each stored file would otherwise be listed under the other's name.
+	entries, err := fssecret.New(os.DirFS(dir), c, n).ReadDir(".")
+	if err != nil || len(entries) != 0 {
+		t.Errorf("ReadDir with swapped sidecars returned %v, %v.", entries, err)
+	}
+}
@@ -63,6 +63,12 @@ func New(key, pad string, compress bool) (*PadSecret, error) {
 	return &PadSecret{naclKey, compress}, nil
 }
 
+// Key returns the 32 bytes key that a PadSecret created with the same key and pad
+// would use. It lets other packages use keys managed the same way as padsecret's.
+func Key(key, pad string) (*[32]byte, error) {
+	return constructKey(key, pad)
+}
+
 func constructKey(key, pad string) (naclKey *[32]byte, e error) {
 	tKey := []byte(key)
 	tPad := []byte(pad)
@@ -0,0 +1,201 @@
+/*
+Package sealed provides Sealed[T], an encrypted container for values of any
+type, for passing encrypted values through JSON APIs, gob caches or text
+formats.
+
+A value is serialized with a Codec (JSON by default, or gob, or your own),
+encrypted with a padsecret or saltsecret cipher, and kept as ciphertext.
+Sealed[T] implements json.Marshaler and json.Unmarshaler (a base64 string),
+encoding.TextMarshaler and encoding.TextUnmarshaler (base64) and
+encoding.BinaryMarshaler and encoding.BinaryUnmarshaler (the raw ciphertext,
+which gob uses too). Open decrypts it back to a T.
+
+The name of the codec is stored inside the ciphertext, so Open needs no
+configuration besides the cipher. Custom codecs have to be registered with
+RegisterCodec on both sides.
+*/
+package sealed
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/andmarios/crypto/nacl/adsecret"
+)
+
+// A Codec serializes values before they are encrypted.
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Built-in codecs.
+var (
+	JSON Codec = jsonCodec{}
+	Gob  Codec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(v)
+	return b.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{"json": JSON, "gob": Gob}
+)
+
+// RegisterCodec makes a codec available to Open. Codec names are limited to
+// 255 bytes.
+func RegisterCodec(c Codec) {
+	if len(c.Name()) == 0 || len(c.Name()) > 255 {
+		panic("sealed: invalid codec name " + c.Name())
+	}
+	codecsMu.Lock()
+	codecs[c.Name()] = c
+	codecsMu.Unlock()
+}
+
+func codec(name string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	return c, ok
+}
+
+// A Sealed holds an encrypted value of type T. The zero Sealed holds no value.
+type Sealed[T any] struct {
+	ct []byte
+}
+
+// Seal serializes v with the JSON codec and encrypts it with c.
+func Seal[T any](c adsecret.Cipher, v T) (Sealed[T], error) {
+	return SealWith(c, JSON, v)
+}
+
+// SealWith serializes v with codec and encrypts it with c.
+func SealWith[T any](c adsecret.Cipher, codec Codec, v T) (Sealed[T], error) {
+	name := codec.Name()
+	if len(name) == 0 || len(name) > 255 {
+		return Sealed[T]{}, errors.New("invalid codec name " + name)
+	}
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return Sealed[T]{}, err
+	}
+	msg := make([]byte, 0, 1+len(name)+len(data))
+	msg = append(msg, byte(len(name)))
+	msg = append(msg, name...)
+	msg = append(msg, data...)
+	ct, err := c.Encrypt(msg)
+	if err != nil {
+		return Sealed[T]{}, err
+	}
+	return Sealed[T]{ct}, nil
+}
+
+// Open decrypts the value with c.
+func (s Sealed[T]) Open(c adsecret.Cipher) (T, error) {
+	var v T
+	if s.ct == nil {
+		return v, errors.New("sealed value is empty")
+	}
+	msg, err := c.Decrypt(s.ct)
+	if err != nil {
+		return v, err
+	}
+	if len(msg) < 1 || len(msg) < 1+int(msg[0]) {
+		return v, errors.New("sealed value is malformed")
+	}
+	name := string(msg[1 : 1+msg[0]])
+	codec, ok := codec(name)
+	if !ok {
+		return v, errors.New("unknown codec " + name)
+	}
+	err = codec.Unmarshal(msg[1+msg[0]:], &v)
+	return v, err
+}
+
+// IsZero reports whether s holds no value.
+func (s Sealed[T]) IsZero() bool {
+	return s.ct == nil
+}
+
+// MarshalJSON encodes the ciphertext as a base64 string, or null if s holds no value.
+func (s Sealed[T]) MarshalJSON() ([]byte, error) {
+	if s.ct == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(s.ct)
+}
+
+// UnmarshalJSON decodes a ciphertext encoded by MarshalJSON.
+func (s *Sealed[T]) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		s.ct = nil
+		return nil
+	}
+	var ct []byte
+	if err := json.Unmarshal(data, &ct); err != nil {
+		return err
+	}
+	s.ct = ct
+	return nil
+}
+
+// MarshalText encodes the ciphertext as base64 (standard encoding).
+func (s Sealed[T]) MarshalText() ([]byte, error) {
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(s.ct)))
+	base64.StdEncoding.Encode(out, s.ct)
+	return out, nil
+}
+
+// UnmarshalText decodes a ciphertext encoded by MarshalText.
+func (s *Sealed[T]) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		s.ct = nil
+		return nil
+	}
+	ct := make([]byte, base64.StdEncoding.DecodedLen(len(text)))
+	n, err := base64.StdEncoding.Decode(ct, text)
+	if err != nil {
+		return err
+	}
+	s.ct = ct[:n]
+	return nil
+}
+
+// MarshalBinary returns the raw ciphertext.
+func (s Sealed[T]) MarshalBinary() ([]byte, error) {
+	return append([]byte(nil), s.ct...), nil
+}
+
+// UnmarshalBinary sets the raw ciphertext.
+func (s *Sealed[T]) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		s.ct = nil
+		return nil
+	}
+	s.ct = append([]byte(nil), data...)
+	return nil
+}
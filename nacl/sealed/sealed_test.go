@@ -0,0 +1,142 @@
+package sealed
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/andmarios/crypto/nacl/padsecret"
+)
+
+const pad = "qwertyuiopasdfghjklzxcvbnm123456"
+
+type account struct {
+	Owner   string
+	Balance int
+}
+
+type record struct {
+	ID      int
+	Account Sealed[account]
+	Token   Sealed[string] `json:",omitempty"`
+}
+
+func TestJSON(t *testing.T) {
+	c, _ := padsecret.New("qwerty", pad, false)
+	s, err := Seal(c, account{"Alice", 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := json.Marshal(record{ID: 1, Account: s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, []byte("Alice")) {
+		t.Errorf("Marshalled JSON leaks the plaintext: %s", out)
+	}
+	if !bytes.Contains(out, []byte(`"Token":null`)) {
+		t.Errorf("Zero Sealed is not marshalled as null: %s", out)
+	}
+
+	var r record
+	if err = json.Unmarshal(out, &r); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Token.IsZero() {
+		t.Errorf("Null did not unmarshal to a zero Sealed.")
+	}
+	a, err := r.Account.Open(c)
+	if err != nil || a != (account{"Alice", 42}) {
+		t.Errorf("Open() returned %+v, %v.", a, err)
+	}
+
+	wrong, _ := padsecret.New("other", pad, false)
+	if _, err = r.Account.Open(wrong); err == nil {
+		t.Errorf("Open() succeeds with the wrong key.")
+	}
+	if _, err = r.Token.Open(c); err == nil {
+		t.Errorf("Open() succeeds on a zero Sealed.")
+	}
+}
+
+func TestGob(t *testing.T) {
+	c, _ := padsecret.New("qwerty", pad, false)
+	s, err := SealWith(c, Gob, account{"Bob", 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err = gob.NewEncoder(&b).Encode(record{ID: 2, Account: s}); err != nil {
+		t.Fatal(err)
+	}
+	var r record
+	if err = gob.NewDecoder(&b).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	a, err := r.Account.Open(c)
+	if err != nil || a != (account{"Bob", 7}) {
+		t.Errorf("Open() returned %+v, %v.", a, err)
+	}
+}
+
+func TestText(t *testing.T) {
+	c, _ := padsecret.New("qwerty", pad, false)
+	s, err := Seal(c, "secret token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := s.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s2 Sealed[string]
+	if err = s2.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s2.Open(c); err != nil || v != "secret token" {
+		t.Errorf("Open() returned '%s', %v.", v, err)
+	}
+	if err = s2.UnmarshalText([]byte("not base64!")); err == nil {
+		t.Errorf("UnmarshalText() accepts invalid base64.")
+	}
+}
+
+type upperCodec struct{}
+
+func (upperCodec) Name() string { return "upper" }
+
+func (upperCodec) Marshal(v interface{}) ([]byte, error) {
+	return []byte(strings.ToUpper(*v.(*string))), nil
+}
+
+func (upperCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*string) = string(data)
+	return nil
+}
+
+func TestCodec(t *testing.T) {
+	c, _ := padsecret.New("qwerty", pad, false)
+	v := "hello"
+	s, err := SealWith(c, upperCodec{}, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Open(c); err == nil {
+		t.Errorf("Open() succeeds with an unregistered codec.")
+	}
+
+	RegisterCodec(upperCodec{})
+	var s2 Sealed[string]
+	b, _ := s.MarshalBinary()
+	if err = s2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := s2.Open(c); err != nil || out != "HELLO" {
+		t.Errorf("Open() returned '%s', %v.", out, err)
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Package sivsecret implements deterministic NaCl secret key encryption with a
+synthetic IV, as a companion of padsecret.
+
+Encrypting the same message with the same key always gives the same
+ciphertext. This allows deduplicating encrypted data and tokenizing
+identifiers, at the cost of revealing which messages are equal. Use padsecret
+for everything else.
+
+The nonce is not random but a keyed BLAKE2b digest of the message (the
+synthetic IV). When decrypting, the digest is computed anew and compared to
+the nonce. This construction is misuse resistant: the only thing an attacker
+learns from repeated messages is that they are repeated.
+
+Two subkeys, one for the digest and one for secretbox, are derived from the
+user key, so the key and pad of a padsecret instance may be reused safely.
+
+Every message starts with a "SIV" header, followed by a flags byte, so that
+deterministic messages are never confused with padsecret's randomized ones.
+padsecret.Decrypt rejects them and Decrypt rejects padsecret messages.
+*/
+package sivsecret
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/subtle"
+	"errors"
+	"io"
+
+	"github.com/andmarios/crypto/nacl/padsecret"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// These are defined in golang.org/x/crypto/nacl/secretbox
+const (
+	keySize   = 32
+	nonceSize = 24
+)
+
+// Header marks deterministic messages.
+const Header = "SIV"
+
+const headerSize = len(Header) + 1
+
+const compressBit byte = 0x01
+
+// A SIVSecret holds the instance's subkeys and the compression settings.
+type SIVSecret struct {
+	macKey   []byte
+	encKey   *[keySize]byte
+	compress bool
+}
+
+// New creates a new SIVSecret instance. key and pad are combined like in
+// padsecret.New (pad should be at least 32 bytes). compress indicates whether
+// the data should be compressed (zlib) before encrypting.
+func New(key, pad string, compress bool) (*SIVSecret, error) {
+	k, err := padsecret.Key(key, pad)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromKey(k, compress), nil
+}
+
+// NewFromKey creates a new SIVSecret instance from a raw 32 bytes key.
+func NewFromKey(key *[32]byte, compress bool) *SIVSecret {
+	encKey := new([keySize]byte)
+	copy(encKey[:], subkey(key, "sivsecret encryption key"))
+	return &SIVSecret{subkey(key, "sivsecret mac key"), encKey, compress}
+}
+
+func subkey(key *[32]byte, label string) []byte {
+	h, _ := blake2b.New256(key[:])
+	h.Write([]byte(label))
+	return h.Sum(nil)
+}
+
+// Encrypt encrypts a message and returns the encrypted msg (header + nonce + ciphertext).
+// The same message always encrypts to the same output.
+// If you have enabled compression, it will compress the msg before encrypting it.
+func (c SIVSecret) Encrypt(msg []byte) ([]byte, error) {
+	flags := byte(0)
+	if c.compress {
+		var b bytes.Buffer
+		w := zlib.NewWriter(&b)
+		w.Write(msg)
+		w.Close()
+		msg = b.Bytes()
+		flags |= compressBit
+	}
+
+	nonce := c.syntheticIV(flags, msg)
+	out := make([]byte, headerSize, headerSize+nonceSize+len(msg)+secretbox.Overhead)
+	copy(out, Header)
+	out[len(Header)] = flags
+	out = append(out, nonce[:]...)
+	return secretbox.Seal(out, msg, nonce, c.encKey), nil
+}
+
+// Decrypt decrypts an encrypted message and returns it (plaintext).
+// If the message was compressed, it wil detect it and decompress
+// the msg after decrypting it.
+func (c SIVSecret) Decrypt(msg []byte) ([]byte, error) {
+	if len(msg) < headerSize+nonceSize+secretbox.Overhead {
+		return nil, errors.New("encrypted message length too short")
+	}
+	if string(msg[:len(Header)]) != Header {
+		return nil, errors.New("not a deterministic (sivsecret) message")
+	}
+	flags := msg[len(Header)]
+	msg = msg[headerSize:]
+
+	nonce := new([nonceSize]byte)
+	copy(nonce[:], msg[:nonceSize])
+	out, ok := secretbox.Open(nil, msg[nonceSize:], nonce, c.encKey)
+	if !ok {
+		return nil, errors.New("could not decrypt message")
+	}
+	expected := c.syntheticIV(flags, out)
+	if subtle.ConstantTimeCompare(expected[:], nonce[:]) != 1 {
+		return nil, errors.New("could not decrypt message")
+	}
+
+	if flags&compressBit == compressBit {
+		r, err := zlib.NewReader(bytes.NewReader(out))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		out, err = io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// IsDeterministic reports whether msg carries the sivsecret header.
+func IsDeterministic(msg []byte) bool {
+	return len(msg) >= headerSize && string(msg[:len(Header)]) == Header
+}
+
+// syntheticIV returns the nonce for a (possibly compressed) message.
+func (c SIVSecret) syntheticIV(flags byte, msg []byte) *[nonceSize]byte {
+	h, _ := blake2b.New(nonceSize, c.macKey)
+	h.Write([]byte{flags})
+	h.Write(msg)
+	nonce := new([nonceSize]byte)
+	copy(nonce[:], h.Sum(nil))
+	return nonce
+}
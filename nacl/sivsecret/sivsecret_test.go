@@ -0,0 +1,85 @@
+package sivsecret
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/andmarios/crypto/nacl/padsecret"
+)
+
+const pad = "qwertyuiopasdfghjklzxcvbnm123456"
+
+func TestPackage(t *testing.T) {
+	if _, err := New("qwerty", "short", false); err == nil {
+		t.Errorf("New() accepts pad smaller than 32 bytes")
+	}
+	c, err := New("qwerty", pad, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, _ := New("qwerty", pad, true)
+
+	msg := []byte("hello world")
+	for _, s := range []*SIVSecret{c, cc} {
+		enc, err := s.Encrypt(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc2, _ := s.Encrypt(msg)
+		if !bytes.Equal(enc, enc2) {
+			t.Errorf("Encrypt() is not deterministic.")
+		}
+		if !IsDeterministic(enc) {
+			t.Errorf("Encrypted message lacks the header.")
+		}
+		other, _ := s.Encrypt([]byte("hello world!"))
+		if bytes.Equal(enc[:headerSize+nonceSize], other[:headerSize+nonceSize]) {
+			t.Errorf("Different messages share a nonce.")
+		}
+
+		dec, err := s.Decrypt(enc)
+		if err != nil {
+			t.Errorf("Decrypt() failed: %v", err)
+		}
+		if !bytes.Equal(dec, msg) {
+			t.Errorf("Decoded message '%s' differs from encoded message '%s'.", dec, msg)
+		}
+
+		// Compression is detected from the header, like in padsecret.
+		if dec, err = c.Decrypt(enc); err != nil || !bytes.Equal(dec, msg) {
+			t.Errorf("Decrypt() with a different compression setting failed: %v", err)
+		}
+
+		enc[len(enc)-1] ^= 0x01
+		if _, err = s.Decrypt(enc); err == nil {
+			t.Errorf("Decrypt() accepts tampered message.")
+		}
+	}
+
+	if _, err = c.Decrypt(msg); err == nil {
+		t.Errorf("Decrypt() doesn't check for errors.")
+	}
+}
+
+func TestNotConfused(t *testing.T) {
+	s, _ := New("qwerty", pad, false)
+	p, _ := padsecret.New("qwerty", pad, false)
+	msg := []byte("hello world")
+
+	det, _ := s.Encrypt(msg)
+	if _, err := p.Decrypt(det); err == nil {
+		t.Errorf("padsecret decrypts a deterministic message.")
+	}
+	rnd, _ := p.Encrypt(msg)
+	if _, err := s.Decrypt(rnd); err == nil {
+		t.Errorf("sivsecret decrypts a randomized message.")
+	}
+
+	other, _ := New("azerty", pad, false)
+	if _, err := other.Decrypt(det); err == nil {
+		t.Errorf("Decrypt() with wrong key succeeds.")
+	}
+	if det2, _ := other.Encrypt(msg); bytes.Equal(det, det2) {
+		t.Errorf("Different keys produce the same ciphertext.")
+	}
+}
@@ -0,0 +1,187 @@
+/*
+Package slogsecret provides a log/slog Handler that encrypts sensitive
+attributes instead of dropping them, so that they can be recovered when
+needed.
+
+The Handler wraps another Handler and encrypts the attributes whose key
+matches one of the configured names. A name without dots matches attributes
+and groups with that key at any depth; a dotted name, such as "user.email",
+matches the full path of an attribute through its groups. All the attributes
+of a matching group are encrypted. Everything else is passed through as is.
+
+An encrypted attribute becomes a string in the format of envsecret:
+
+	"password":"enc:v1:<base64 ciphertext>"
+
+The ciphertext holds the JSON encoding of the value, so Decode can restore
+numbers, objects and strings alike. Decode reads a log stream, JSON or text,
+and replaces every encrypted value with its decrypted JSON.
+*/
+package slogsecret
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/andmarios/crypto/nacl/adsecret"
+	"github.com/andmarios/crypto/nacl/envsecret"
+)
+
+// Failed replaces attributes that could not be encrypted.
+const Failed = "[encryption failed]"
+
+// A Handler encrypts the matching attributes of records and passes them to
+// the wrapped Handler.
+type Handler struct {
+	h      slog.Handler
+	c      adsecret.Cipher
+	names  map[string]bool
+	groups []string
+}
+
+// NewHandler creates a new Handler that passes records to h after encrypting
+// the attributes matching names with c, usually a padsecret.PadSecret.
+func NewHandler(h slog.Handler, c adsecret.Cipher, names ...string) *Handler {
+	m := make(map[string]bool, len(names))
+	for _, n := range names {
+		m[n] = true
+	}
+	return &Handler{h: h, c: c, names: m}
+}
+
+// Enabled reports whether the wrapped Handler handles records at level.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.h.Enabled(ctx, level)
+}
+
+// Handle encrypts the matching attributes of r and passes it on.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.attr(a, h.groups))
+		return true
+	})
+	return h.h.Handle(ctx, out)
+}
+
+// WithAttrs returns a Handler whose wrapped Handler has the attributes attrs,
+// encrypted if they match.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = h.attr(a, h.groups)
+	}
+	h2 := *h
+	h2.h = h.h.WithAttrs(out)
+	return &h2
+}
+
+// WithGroup returns a Handler that starts the group name.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.h = h.h.WithGroup(name)
+	h2.groups = append(h.groups[:len(h.groups):len(h.groups)], name)
+	return &h2
+}
+
+// match reports whether the attribute at path should be encrypted.
+func (h *Handler) match(path []string) bool {
+	for i, p := range path {
+		if h.names[p] || h.names[strings.Join(path[:i+1], ".")] {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) attr(a slog.Attr, groups []string) slog.Attr {
+	path := append(groups[:len(groups):len(groups)], a.Key)
+	a.Value = a.Value.Resolve()
+	if h.match(path) {
+		return slog.String(a.Key, h.encrypt(a.Value))
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		attrs := a.Value.Group()
+		out := make([]slog.Attr, len(attrs))
+		for i, ga := range attrs {
+			out[i] = h.attr(ga, path)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(out...)}
+	}
+	return a
+}
+
+func (h *Handler) encrypt(v slog.Value) string {
+	b, err := json.Marshal(jsonValue(v))
+	if err != nil {
+		return Failed
+	}
+	ct, err := h.c.Encrypt(b)
+	if err != nil {
+		return Failed
+	}
+	return envsecret.Prefix + base64.StdEncoding.EncodeToString(ct)
+}
+
+// jsonValue returns a value that encodes to JSON like slog.JSONHandler
+// writes v.
+func jsonValue(v slog.Value) interface{} {
+	v = v.Resolve()
+	switch v.Kind() {
+	case slog.KindGroup:
+		m := make(map[string]interface{})
+		for _, a := range v.Group() {
+			m[a.Key] = jsonValue(a.Value)
+		}
+		return m
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return err.Error()
+		}
+	}
+	return v.Any()
+}
+
+// encrypted matches encrypted values, quoted in JSON logs or bare in text logs.
+var encrypted = regexp.MustCompile(`"?` + regexp.QuoteMeta(envsecret.Prefix) + `[A-Za-z0-9+/]+={0,2}"?`)
+
+// Decode copies the log stream r to w, replacing every encrypted value with
+// its decrypted JSON. Values that can not be decrypted are left as they are
+// and the first error is returned once the stream is copied.
+func Decode(r io.Reader, w io.Writer, c adsecret.Cipher) error {
+	var firstErr error
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 16<<20)
+	for s.Scan() {
+		line := encrypted.ReplaceAllFunc(s.Bytes(), func(m []byte) []byte {
+			if (m[0] == '"') != (m[len(m)-1] == '"') {
+				// Part of a longer string.
+				return m
+			}
+			in, err := base64.StdEncoding.DecodeString(strings.Trim(string(m), `"`)[len(envsecret.Prefix):])
+			if err == nil {
+				var out []byte
+				if out, err = c.Decrypt(in); err == nil {
+					return out
+				}
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			return m
+		})
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	return firstErr
+}
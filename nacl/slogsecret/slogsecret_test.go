@@ -0,0 +1,93 @@
+package slogsecret
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/andmarios/crypto/nacl/padsecret"
+)
+
+const pad = "qwertyuiopasdfghjklzxcvbnm123456"
+
+func TestHandler(t *testing.T) {
+	c, _ := padsecret.New("qwerty", pad, false)
+	var b bytes.Buffer
+	h := NewHandler(slog.NewJSONHandler(&b, nil), c, "password", "user.email", "card")
+	l := slog.New(h)
+
+	l.With("password", "with attrs").Info("login",
+		"user", "alice",
+		slog.Group("user", "email", "alice@example.com", "name", "Alice"),
+		slog.Group("card", "number", "4111111111111111", "cvv", 123),
+		"password", "hunter2",
+		"attempts", 3,
+		"err", errors.New("bad password"))
+	l.WithGroup("user").Info("update", "email", "bob@example.com", "name", "Bob")
+	l.WithGroup("card").Info("charge", "number", "5500000000000004")
+
+	out := b.String()
+	for _, s := range []string{"with attrs", "alice@example.com", "4111111111111111", "hunter2", "bob@example.com", "5500000000000004"} {
+		if strings.Contains(out, s) {
+			t.Errorf("Log contains '%s':\n%s", s, out)
+		}
+	}
+	for _, s := range []string{`"user":"alice"`, `"name":"Alice"`, `"attempts":3`, `"err":"bad password"`, `"name":"Bob"`} {
+		if !strings.Contains(out, s) {
+			t.Errorf("Log lacks '%s':\n%s", s, out)
+		}
+	}
+
+	var dec bytes.Buffer
+	if err := Decode(strings.NewReader(out), &dec, c); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(dec.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Decode() returned %d lines:\n%s", len(lines), dec.String())
+	}
+	var first struct {
+		Password string
+		User     interface{}
+		Card     struct {
+			Number string
+			CVV    int
+		}
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("Decode() returned invalid JSON: %v\n%s", err, lines[0])
+	}
+	if first.Password != "hunter2" || first.Card.Number != "4111111111111111" || first.Card.CVV != 123 {
+		t.Errorf("Decode() returned %+v.", first)
+	}
+	for _, s := range []string{`"password":"with attrs"`, `"email":"alice@example.com"`} {
+		if !strings.Contains(lines[0], s) {
+			t.Errorf("Decoded line lacks '%s':\n%s", s, lines[0])
+		}
+	}
+	if !strings.Contains(lines[1], `"user":{"email":"bob@example.com","name":"Bob"}`) || !strings.Contains(lines[2], `"number":"5500000000000004"`) {
+		t.Errorf("Decode() did not restore grouped attributes:\n%s", dec.String())
+	}
+
+	var wrong bytes.Buffer
+	other, _ := padsecret.New("other", pad, false)
+	if err := Decode(strings.NewReader(out), &wrong, other); err == nil || wrong.String() != out {
+		t.Errorf("Decode() with the wrong key returned %v.", err)
+	}
+}
+
+func TestText(t *testing.T) {
+	c, _ := padsecret.New("qwerty", pad, false)
+	var b bytes.Buffer
+	slog.New(NewHandler(slog.NewTextHandler(&b, nil), c, "token")).Info("call", "token", "abc def", "id", 7)
+	if strings.Contains(b.String(), "abc") {
+		t.Errorf("Log contains the token:\n%s", b.String())
+	}
+	var dec bytes.Buffer
+	if err := Decode(&b, &dec, c); err != nil || !strings.Contains(dec.String(), `token="abc def" id=7`) {
+		t.Errorf("Decode() returned %v:\n%s", err, dec.String())
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Package sqlsecret provides database/sql column types that are encrypted with
+padsecret or saltsecret.
+
+EncryptedString, EncryptedBytes and EncryptedJSON implement driver.Valuer, so
+they are encrypted when passed as query arguments, and sql.Scanner, so they
+are decrypted when scanned from a result. The ciphertext is stored as raw
+bytes; use a binary column type (BLOB, BYTEA, VARBINARY).
+
+Every value is bound to a Field: the table, the column and the row identity
+(usually the primary key) it belongs to. These are bound to the ciphertext as
+associated data (see adsecret), so an encrypted value copied to another row
+or column fails to decrypt. This means the row identity has to be known before
+inserting, which rules out identities generated by the database.
+
+	s := sqlsecret.New(cipher)
+	email := sqlsecret.EncryptedString{Field: s.Field("users", "email", id), String: "alice@example.com"}
+	_, err := db.Exec("INSERT INTO users (id, email) VALUES (?, ?)", id, email)
+
+	email = sqlsecret.EncryptedString{Field: s.Field("users", "email", id)}
+	err = db.QueryRow("SELECT email FROM users WHERE id = ?", id).Scan(&email)
+
+A NULL column scans as the zero value.
+*/
+package sqlsecret
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/andmarios/crypto/nacl/adsecret"
+)
+
+// A SQLSecret holds the cipher used by encrypted columns.
+type SQLSecret struct {
+	c *adsecret.ADSecret
+}
+
+// New creates a new SQLSecret instance that encrypts with c, usually a
+// padsecret.PadSecret. Saltsecret works too, but its key derivation makes
+// every value slow to read and write.
+func New(c adsecret.Cipher) *SQLSecret {
+	return &SQLSecret{adsecret.New(c)}
+}
+
+// A Field identifies the cell an encrypted value belongs to.
+type Field struct {
+	s  *SQLSecret
+	ad []byte
+}
+
+// Field returns the Field for column of the row identified by row in table.
+// row is formatted with fmt.Sprint, so any printable primary key will do.
+func (s *SQLSecret) Field(table, column string, row interface{}) Field {
+	return Field{s, adsecret.Join("sqlsecret", table, column, fmt.Sprint(row))}
+}
+
+func (f Field) encrypt(msg []byte) (driver.Value, error) {
+	if f.s == nil {
+		return nil, errors.New("sqlsecret value is not bound to a field")
+	}
+	return f.s.c.Encrypt(msg, f.ad)
+}
+
+// decrypt decrypts a scanned value. It returns nil for NULL.
+func (f Field) decrypt(src interface{}) ([]byte, error) {
+	if f.s == nil {
+		return nil, errors.New("sqlsecret value is not bound to a field")
+	}
+	var msg []byte
+	switch v := src.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		msg = v
+	case string:
+		msg = []byte(v)
+	default:
+		return nil, fmt.Errorf("sqlsecret can not scan %T", src)
+	}
+	return f.s.c.Decrypt(msg, f.ad)
+}
+
+// An EncryptedString is a string stored encrypted.
+type EncryptedString struct {
+	Field
+	String string
+}
+
+// Value implements driver.Valuer.
+func (e EncryptedString) Value() (driver.Value, error) {
+	return e.encrypt([]byte(e.String))
+}
+
+// Scan implements sql.Scanner.
+func (e *EncryptedString) Scan(src interface{}) error {
+	out, err := e.decrypt(src)
+	if err != nil {
+		return err
+	}
+	e.String = string(out)
+	return nil
+}
+
+// An EncryptedBytes is a byte slice stored encrypted.
+type EncryptedBytes struct {
+	Field
+	Bytes []byte
+}
+
+// Value implements driver.Valuer.
+func (e EncryptedBytes) Value() (driver.Value, error) {
+	return e.encrypt(e.Bytes)
+}
+
+// Scan implements sql.Scanner.
+func (e *EncryptedBytes) Scan(src interface{}) error {
+	out, err := e.decrypt(src)
+	if err != nil {
+		return err
+	}
+	e.Bytes = out
+	return nil
+}
+
+// An EncryptedJSON is a value of type T stored as encrypted JSON.
+type EncryptedJSON[T any] struct {
+	Field
+	V T
+}
+
+// Value implements driver.Valuer.
+func (e EncryptedJSON[T]) Value() (driver.Value, error) {
+	msg, err := json.Marshal(e.V)
+	if err != nil {
+		return nil, err
+	}
+	return e.encrypt(msg)
+}
+
+// Scan implements sql.Scanner.
+func (e *EncryptedJSON[T]) Scan(src interface{}) error {
+	out, err := e.decrypt(src)
+	if err != nil {
+		return err
+	}
+	var v T
+	if out != nil {
+		if err = json.Unmarshal(out, &v); err != nil {
+			return err
+		}
+	}
+	e.V = v
+	return nil
+}
@@ -0,0 +1,204 @@
+package sqlsecret
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andmarios/crypto/nacl/padsecret"
+)
+
+// fakeDriver is an in-memory driver that understands two statements:
+//
+//	INSERT <table> <column> (args: row, value)
+//	SELECT <table> <column> (args: row)
+type fakeDriver struct {
+	mu    sync.Mutex
+	cells map[string]driver.Value
+}
+
+var fake = &fakeDriver{cells: make(map[string]driver.Value)}
+
+func init() {
+	sql.Register("sqlsecret-fake", fake)
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	return fakeConn{d}, nil
+}
+
+type fakeConn struct {
+	d *fakeDriver
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	f := strings.Fields(query)
+	if len(f) != 3 || (f[0] != "INSERT" && f[0] != "SELECT") {
+		return nil, errors.New("unsupported statement: " + query)
+	}
+	return fakeStmt{c.d, f[0], f[1] + "." + f[2]}, nil
+}
+
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type fakeStmt struct {
+	d      *fakeDriver
+	op     string
+	column string
+}
+
+func (s fakeStmt) Close() error { return nil }
+
+func (s fakeStmt) NumInput() int {
+	if s.op == "INSERT" {
+		return 2
+	}
+	return 1
+}
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.d.cells[s.column+"."+args[0].(string)] = args[1]
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	v, ok := s.d.cells[s.column+"."+args[0].(string)]
+	if !ok {
+		return &fakeRows{}, nil
+	}
+	return &fakeRows{[]driver.Value{v}}, nil
+}
+
+type fakeRows struct {
+	values []driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return []string{"value"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0] = r.values[0]
+	r.values = r.values[1:]
+	return nil
+}
+
+func newSQLSecret(t *testing.T) (*SQLSecret, *sql.DB) {
+	c, err := padsecret.New("qwerty", "qwertyuiopasdfghjklzxcvbnm123456", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlsecret-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(c), db
+}
+
+type address struct {
+	Street string
+	Zip    int
+}
+
+func TestRoundTrip(t *testing.T) {
+	s, db := newSQLSecret(t)
+	defer db.Close()
+
+	email := EncryptedString{Field: s.Field("users", "email", 1), String: "alice@example.com"}
+	photo := EncryptedBytes{Field: s.Field("users", "photo", 1), Bytes: []byte{0, 1, 2, 3}}
+	addr := EncryptedJSON[address]{Field: s.Field("users", "address", 1), V: address{"Main St. 1", 12345}}
+	for _, q := range []struct {
+		query string
+		value interface{}
+	}{
+		{"INSERT users email", email},
+		{"INSERT users photo", photo},
+		{"INSERT users address", addr},
+	} {
+		if _, err := db.Exec(q.query, "1", q.value); err != nil {
+			t.Fatalf("%s failed: %v", q.query, err)
+		}
+	}
+
+	if stored := fake.cells["users.email.1"].([]byte); bytes.Contains(stored, []byte("alice")) {
+		t.Errorf("Value was stored in plaintext.")
+	}
+
+	gotEmail := EncryptedString{Field: s.Field("users", "email", 1)}
+	if err := db.QueryRow("SELECT users email", "1").Scan(&gotEmail); err != nil {
+		t.Errorf("Scan() of EncryptedString failed: %v", err)
+	}
+	if gotEmail.String != email.String {
+		t.Errorf("Scanned string '%s' differs from stored string '%s'.", gotEmail.String, email.String)
+	}
+
+	gotPhoto := EncryptedBytes{Field: s.Field("users", "photo", 1)}
+	if err := db.QueryRow("SELECT users photo", "1").Scan(&gotPhoto); err != nil {
+		t.Errorf("Scan() of EncryptedBytes failed: %v", err)
+	}
+	if !bytes.Equal(gotPhoto.Bytes, photo.Bytes) {
+		t.Errorf("Scanned bytes '%v' differ from stored bytes '%v'.", gotPhoto.Bytes, photo.Bytes)
+	}
+
+	gotAddr := EncryptedJSON[address]{Field: s.Field("users", "address", 1)}
+	if err := db.QueryRow("SELECT users address", "1").Scan(&gotAddr); err != nil {
+		t.Errorf("Scan() of EncryptedJSON failed: %v", err)
+	}
+	if gotAddr.V != addr.V {
+		t.Errorf("Scanned JSON '%v' differs from stored JSON '%v'.", gotAddr.V, addr.V)
+	}
+}
+
+func TestSwappedValues(t *testing.T) {
+	s, db := newSQLSecret(t)
+	defer db.Close()
+
+	for _, id := range []string{"10", "11"} {
+		v := EncryptedString{Field: s.Field("users", "email", id), String: id + "@example.com"}
+		if _, err := db.Exec("INSERT users email", id, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Copy row 11's ciphertext into row 10, and into another column.
+	fake.cells["users.email.10"] = fake.cells["users.email.11"]
+	fake.cells["users.name.11"] = fake.cells["users.email.11"]
+
+	v := EncryptedString{Field: s.Field("users", "email", 10)}
+	if err := db.QueryRow("SELECT users email", "10").Scan(&v); err == nil {
+		t.Errorf("Scan() accepts a value swapped from another row.")
+	}
+	v = EncryptedString{Field: s.Field("users", "name", 11)}
+	if err := db.QueryRow("SELECT users name", "11").Scan(&v); err == nil {
+		t.Errorf("Scan() accepts a value swapped from another column.")
+	}
+}
+
+func TestUnbound(t *testing.T) {
+	if _, err := (EncryptedString{String: "hello"}).Value(); err == nil {
+		t.Errorf("Value() of an unbound value does not fail.")
+	}
+	var v EncryptedBytes
+	if err := v.Scan([]byte("hello")); err == nil {
+		t.Errorf("Scan() into an unbound value does not fail.")
+	}
+}
+
+func TestNull(t *testing.T) {
+	s, _ := newSQLSecret(t)
+	v := EncryptedJSON[address]{Field: s.Field("users", "address", 1), V: address{"x", 1}}
+	if err := v.Scan(nil); err != nil || v.V != (address{}) {
+		t.Errorf("Scan(nil) returned %v, %v.", v.V, err)
+	}
+}
@@ -0,0 +1,231 @@
+/*
+Package structsecret encrypts tagged struct fields in place with padsecret or
+saltsecret.
+
+Fields are marked with the tag `crypto:"encrypt"`:
+
+	type User struct {
+		Name     string
+		Email    string `crypto:"encrypt"`
+		Document []byte `crypto:"encrypt"`
+	}
+
+Seal walks a struct with reflection and replaces every tagged field with its
+ciphertext: strings hold the base64 (standard encoding) ciphertext, byte
+slices the raw ciphertext. Open reverses it. Nested and embedded structs,
+pointers, slices, map values and interfaces are walked too. A tagged field
+may also be a pointer to a string or a byte slice. Fields are only changed
+if all of them are encrypted or decrypted: on an error, v is left as it was.
+
+Pointers are followed once: cyclic structures are walked to their end and a
+field shared through a pointer is encrypted once. Seal and Open do not track
+the state of a struct otherwise; sealing a struct twice encrypts its fields
+twice.
+*/
+package structsecret
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+
+	"github.com/andmarios/crypto/nacl/adsecret"
+)
+
+// TagName and TagValue mark the fields to encrypt.
+const (
+	TagName  = "crypto"
+	TagValue = "encrypt"
+)
+
+// A StructSecret holds the cipher used for the fields.
+type StructSecret struct {
+	c adsecret.Cipher
+}
+
+// New creates a new StructSecret instance that encrypts with c, usually a
+// padsecret.PadSecret or saltsecret.SaltSecret.
+func New(c adsecret.Cipher) *StructSecret {
+	return &StructSecret{c}
+}
+
+// Seal encrypts in place the tagged fields of the struct v points to.
+func (s StructSecret) Seal(v interface{}) error {
+	return s.walk(v, true)
+}
+
+// Open decrypts in place the tagged fields of the struct v points to.
+func (s StructSecret) Open(v interface{}) error {
+	return s.walk(v, false)
+}
+
+func (s StructSecret) walk(v interface{}, seal bool) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("structsecret needs a non-nil pointer to a struct")
+	}
+	w := &walker{seen: map[visit]bool{{rv.Pointer(), rv.Type()}: true}}
+	if err := s.walkValue(rv.Elem(), seal, w); err != nil {
+		return err
+	}
+	// Nothing is changed until every field is encrypted or decrypted.
+	for _, set := range w.sets {
+		set()
+	}
+	return nil
+}
+
+// A visit is a pointer or map that was followed. The type tells apart a
+// pointer to a struct from a pointer to its first field.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+// A walker holds the pointers followed and the changes to make, in order.
+type walker struct {
+	seen map[visit]bool
+	sets []func()
+}
+
+// follow reports whether the pointer or map v was not seen before, and
+// marks it.
+func (w *walker) follow(v reflect.Value) bool {
+	k := visit{v.Pointer(), v.Type()}
+	if w.seen[k] {
+		return false
+	}
+	w.seen[k] = true
+	return true
+}
+
+// walkCopy walks a copy of v, which is not settable, and calls set with the
+// copy if a field under it changes. canSet tells whether set may be called.
+func (s StructSecret) walkCopy(v reflect.Value, seal bool, w *walker, canSet bool, set func(c reflect.Value)) error {
+	c := reflect.New(v.Type()).Elem()
+	c.Set(v)
+	n := len(w.sets)
+	if err := s.walkValue(c, seal, w); err != nil {
+		return err
+	}
+	if len(w.sets) > n {
+		if !canSet {
+			return errors.New("structsecret can not set tagged fields in " + v.Type().String())
+		}
+		w.sets = append(w.sets, func() { set(c) })
+	}
+	return nil
+}
+
+// walkValue walks structs, pointers, slices, maps and interfaces looking for
+// tagged fields.
+func (s StructSecret) walkValue(v reflect.Value, seal bool, w *walker) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || !w.follow(v) {
+			return nil
+		}
+		return s.walkValue(v.Elem(), seal, w)
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		// Values stored in interfaces are not settable, pointers are.
+		e := v.Elem()
+		if e.Kind() == reflect.Ptr {
+			return s.walkValue(e, seal, w)
+		}
+		return s.walkCopy(e, seal, w, v.CanSet(), func(c reflect.Value) { v.Set(c) })
+	case reflect.Map:
+		if v.IsNil() || !w.follow(v) {
+			return nil
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			k := iter.Key()
+			if err := s.walkCopy(iter.Value(), seal, w, v.CanInterface(), func(c reflect.Value) { v.SetMapIndex(k, c) }); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := s.walkValue(v.Index(i), seal, w); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Tag.Get(TagName) != TagValue {
+				// The exported fields of embedded structs are promoted and
+				// settable, even if the struct type is unexported.
+				if f.IsExported() || f.Anonymous {
+					if err := s.walkValue(v.Field(i), seal, w); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if !f.IsExported() {
+				return errors.New("structsecret can not encrypt unexported field " + t.Name() + "." + f.Name)
+			}
+			if err := s.crypt(v.Field(i), seal, w); err != nil {
+				return errors.New("structsecret field " + t.Name() + "." + f.Name + ": " + err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// crypt encrypts or decrypts a tagged field, recording the change in w.
+func (s StructSecret) crypt(v reflect.Value, seal bool, w *walker) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() || !w.follow(v) {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if !v.CanSet() {
+		return errors.New("field is not settable")
+	}
+
+	switch {
+	case v.Kind() == reflect.String:
+		if seal {
+			out, err := s.c.Encrypt([]byte(v.String()))
+			if err != nil {
+				return err
+			}
+			w.sets = append(w.sets, func() { v.SetString(base64.StdEncoding.EncodeToString(out)) })
+			return nil
+		}
+		in, err := base64.StdEncoding.DecodeString(v.String())
+		if err != nil {
+			return err
+		}
+		out, err := s.c.Decrypt(in)
+		if err != nil {
+			return err
+		}
+		w.sets = append(w.sets, func() { v.SetString(string(out)) })
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		var out []byte
+		var err error
+		if seal {
+			out, err = s.c.Encrypt(v.Bytes())
+		} else {
+			out, err = s.c.Decrypt(v.Bytes())
+		}
+		if err != nil {
+			return err
+		}
+		w.sets = append(w.sets, func() { v.SetBytes(out) })
+	default:
+		return errors.New("unsupported type " + v.Type().String())
+	}
+	return nil
+}
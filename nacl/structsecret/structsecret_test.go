@@ -0,0 +1,220 @@
+package structsecret
+
+import (
+	"bytes"
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"github.com/andmarios/crypto/nacl/padsecret"
+)
+
+type card struct {
+	Holder string
+	Number string `crypto:"encrypt"`
+}
+
+type user struct {
+	Name     string
+	Email    string  `crypto:"encrypt"`
+	Document []byte  `crypto:"encrypt"`
+	Phone    *string `crypto:"encrypt"`
+	Fax      *string `crypto:"encrypt"`
+	Primary  card
+	Backup   *card
+	Cards    []card
+	Extra    interface{}
+}
+
+func newStructSecret(t *testing.T) *StructSecret {
+	c, err := padsecret.New("qwerty", "qwertyuiopasdfghjklzxcvbnm123456", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(c)
+}
+
+func TestPackage(t *testing.T) {
+	s := newStructSecret(t)
+	phone := "+30 210 1234567"
+	u := user{
+		Name:     "Alice",
+		Email:    "alice@example.com",
+		Document: []byte{1, 2, 3},
+		Phone:    &phone,
+		Primary:  card{"Alice", "4111111111111111"},
+		Backup:   &card{"Alice", "5500000000000004"},
+		Cards:    []card{{"Alice", "340000000000009"}},
+		Extra:    &card{"Bob", "30000000000004"},
+	}
+	orig := u
+	origPhone := phone
+	origExtra := *u.Extra.(*card)
+
+	if err := s.Seal(&u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "Alice" || u.Primary.Holder != "Alice" {
+		t.Errorf("Seal() changed untagged fields.")
+	}
+	for _, f := range []string{u.Email, *u.Phone, u.Primary.Number, u.Backup.Number, u.Cards[0].Number, u.Extra.(*card).Number} {
+		if _, err := base64.StdEncoding.DecodeString(f); err != nil {
+			t.Errorf("Sealed field '%s' is not base64: %v", f, err)
+		}
+	}
+	if bytes.Equal(u.Document, orig.Document) {
+		t.Errorf("Seal() did not encrypt the byte slice.")
+	}
+	if u.Fax != nil {
+		t.Errorf("Seal() touched a nil pointer.")
+	}
+
+	if err := s.Open(&u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Email != orig.Email || !bytes.Equal(u.Document, []byte{1, 2, 3}) || *u.Phone != origPhone {
+		t.Errorf("Open() did not restore the top level fields: %+v", u)
+	}
+	if u.Primary != (card{"Alice", "4111111111111111"}) || *u.Backup != (card{"Alice", "5500000000000004"}) {
+		t.Errorf("Open() did not restore nested structs: %+v, %+v", u.Primary, *u.Backup)
+	}
+	if !reflect.DeepEqual(u.Cards, []card{{"Alice", "340000000000009"}}) || *u.Extra.(*card) != origExtra {
+		t.Errorf("Open() did not restore slices and interfaces: %+v, %+v", u.Cards, u.Extra)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	s := newStructSecret(t)
+
+	if err := s.Seal(user{}); err == nil {
+		t.Errorf("Seal() accepts a struct value.")
+	}
+	var nilUser *user
+	if err := s.Seal(nilUser); err == nil {
+		t.Errorf("Seal() accepts a nil pointer.")
+	}
+
+	bad := struct {
+		Age int `crypto:"encrypt"`
+	}{42}
+	if err := s.Seal(&bad); err == nil {
+		t.Errorf("Seal() accepts unsupported field types.")
+	}
+
+	hidden := struct {
+		secret string `crypto:"encrypt"`
+	}{"x"}
+	if err := s.Seal(&hidden); err == nil {
+		t.Errorf("Seal() accepts unexported tagged fields.")
+	}
+
+	u := user{Email: "not encrypted"}
+	if err := s.Open(&u); err == nil {
+		t.Errorf("Open() accepts plaintext fields.")
+	}
+}
+
+type node struct {
+	Parent *node
+	Child  *node
+	Secret string `crypto:"encrypt"`
+}
+
+func TestCycle(t *testing.T) {
+	s := newStructSecret(t)
+	root := &node{Secret: "root"}
+	root.Child = &node{Parent: root, Secret: "child"}
+	root.Parent = root
+	if err := s.Seal(root); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(root); err != nil {
+		t.Fatal(err)
+	}
+	if root.Secret != "root" || root.Child.Secret != "child" {
+		t.Errorf("Cyclic struct opened to %q, %q.", root.Secret, root.Child.Secret)
+	}
+}
+
+func TestSharedPointer(t *testing.T) {
+	s := newStructSecret(t)
+	phone := "+30 210 1234567"
+	backup := &card{Number: "4111"}
+	u := user{Phone: &phone, Fax: &phone, Backup: backup, Cards: []card{{Number: "5500"}}, Extra: backup}
+	if err := s.Seal(&u); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(&u); err != nil {
+		t.Fatal(err)
+	}
+	if phone != "+30 210 1234567" || backup.Number != "4111" {
+		t.Errorf("Shared fields opened to %q, %q.", phone, backup.Number)
+	}
+}
+
+type contact struct {
+	Email string `crypto:"encrypt"`
+}
+
+type profile struct {
+	contact
+	Cards  map[string]card
+	Backup map[string]*card
+	Extra  interface{}
+}
+
+func TestEmbeddedMapsInterfaces(t *testing.T) {
+	s := newStructSecret(t)
+	p := profile{
+		contact: contact{"alice@example.com"},
+		Cards:   map[string]card{"main": {"Alice", "4111"}},
+		Backup:  map[string]*card{"old": {"Alice", "5500"}},
+		Extra:   card{"Bob", "3400"},
+	}
+	if err := s.Seal(&p); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{p.Email, p.Cards["main"].Number, p.Backup["old"].Number, p.Extra.(card).Number} {
+		if f == "alice@example.com" || len(f) < 20 {
+			t.Errorf("Seal() left %q in plaintext.", f)
+		}
+	}
+	if err := s.Open(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Email != "alice@example.com" || p.Cards["main"].Number != "4111" || p.Backup["old"].Number != "5500" || p.Extra.(card).Number != "3400" {
+		t.Errorf("Open() returned %+v.", p)
+	}
+}
+
+type cards []card
+
+func TestUnsettable(t *testing.T) {
+	s := newStructSecret(t)
+	// The elements of an embedded unexported slice are read-only.
+	v := struct{ cards }{cards{{"Alice", "4111"}}}
+	if err := s.Seal(&v); err == nil || v.cards[0].Number != "4111" {
+		t.Errorf("Seal() returned %v and %q for a read-only field.", err, v.cards[0].Number)
+	}
+}
+
+func TestAtomic(t *testing.T) {
+	s := newStructSecret(t)
+	bad := struct {
+		Email string `crypto:"encrypt"`
+		Age   int    `crypto:"encrypt"`
+	}{"alice@example.com", 42}
+	if err := s.Seal(&bad); err == nil || bad.Email != "alice@example.com" {
+		t.Errorf("Seal() returned %v and changed the struct to %+v.", err, bad)
+	}
+
+	u := user{Email: "alice@example.com", Cards: []card{{Number: "not encrypted"}}}
+	if err := s.Seal(&u); err != nil {
+		t.Fatal(err)
+	}
+	sealed := u.Email
+	u.Cards[0].Number = "not encrypted"
+	if err := s.Open(&u); err == nil || u.Email != sealed {
+		t.Errorf("Open() returned %v and changed the struct to %+v.", err, u)
+	}
+}
@@ -0,0 +1,210 @@
+package tarsecret
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Header starts every encrypted stream. It is followed by the scrypt N power
+// (1 byte), the salt (32 bytes) and the nonce prefix (16 bytes).
+const Header = "TARSECRET1"
+
+// ChunkSize is the size of the plaintext chunks of a stream.
+const ChunkSize = 64 << 10
+
+// NPow is the N power of two iterations of scrypt, like saltsecret's.
+const NPow = 14
+
+// maxNPow limits the work a stream header can ask for.
+const maxNPow = 20
+
+const (
+	keySize     = 32
+	saltSize    = 32
+	prefixSize  = 16
+	nonceSize   = 24
+	headerSize  = len(Header) + 1 + saltSize + prefixSize
+	sealedChunk = ChunkSize + secretbox.Overhead
+	finalBit    = 1 << 63
+)
+
+// ErrTruncated is returned by a Reader when the stream ends before its final
+// chunk.
+var ErrTruncated = errors.New("encrypted stream is truncated")
+
+// stream holds the key and the nonce state of a stream.
+type stream struct {
+	key     [keySize]byte
+	nonce   [nonceSize]byte
+	counter uint64
+}
+
+func newStream(key, salt []byte, npow uint) (*stream, error) {
+	k, err := scrypt.Key(key, salt, 2<<npow, 8, 1, keySize)
+	if err != nil {
+		return nil, err
+	}
+	s := &stream{}
+	copy(s.key[:], k)
+	return s, nil
+}
+
+// nextNonce returns the nonce of the next chunk: the random prefix followed by
+// the chunk counter, with the top bit set for the final chunk.
+func (s *stream) nextNonce(final bool) *[nonceSize]byte {
+	c := s.counter
+	if final {
+		c |= finalBit
+	}
+	binary.BigEndian.PutUint64(s.nonce[prefixSize:], c)
+	s.counter++
+	return &s.nonce
+}
+
+// A Writer encrypts a stream in chunks. Close writes the final chunk and has
+// to be called.
+type Writer struct {
+	w   io.Writer
+	s   *stream
+	buf []byte
+	err error
+}
+
+// NewWriter creates a Writer that encrypts to w with a key derived from key
+// and a random salt by scrypt.
+func NewWriter(w io.Writer, key []byte) (*Writer, error) {
+	head := make([]byte, headerSize)
+	copy(head, Header)
+	head[len(Header)] = NPow
+	if _, err := io.ReadFull(rand.Reader, head[len(Header)+1:]); err != nil {
+		return nil, err
+	}
+	s, err := newStream(key, head[len(Header)+1:len(Header)+1+saltSize], NPow)
+	if err != nil {
+		return nil, err
+	}
+	copy(s.nonce[:], head[len(Header)+1+saltSize:])
+	if _, err = w.Write(head); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, s: s, buf: make([]byte, 0, ChunkSize)}, nil
+}
+
+// Write encrypts p, writing every full chunk.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := 0
+	for len(p) > 0 {
+		m := copy(w.buf[len(w.buf):ChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		p = p[m:]
+		n += m
+		// The last full chunk may be the final one; keep it until more data
+		// or Close comes.
+		if len(w.buf) == ChunkSize && len(p) > 0 {
+			if w.err = w.flush(false); w.err != nil {
+				return n, w.err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *Writer) flush(final bool) error {
+	out := secretbox.Seal(nil, w.buf, w.s.nextNonce(final), &w.s.key)
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(out)
+	return err
+}
+
+// Close writes the final chunk. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.flush(true)
+	if w.err == nil {
+		w.err = errors.New("tarsecret writer is closed")
+		return nil
+	}
+	return w.err
+}
+
+// A Reader decrypts a stream written by a Writer. It returns io.EOF only
+// after the final chunk was authenticated.
+type Reader struct {
+	r    *bufio.Reader
+	s    *stream
+	in   []byte
+	out  []byte
+	buf  []byte
+	done bool
+}
+
+// NewReader creates a Reader that decrypts r with key.
+func NewReader(r io.Reader, key []byte) (*Reader, error) {
+	head := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, head); err != nil || string(head[:len(Header)]) != Header {
+		return nil, errors.New("not a tarsecret stream")
+	}
+	npow := uint(head[len(Header)])
+	if npow > maxNPow {
+		return nil, errors.New("tarsecret stream asks for too many scrypt iterations")
+	}
+	s, err := newStream(key, head[len(Header)+1:len(Header)+1+saltSize], npow)
+	if err != nil {
+		return nil, err
+	}
+	copy(s.nonce[:], head[len(Header)+1+saltSize:])
+	return &Reader{r: bufio.NewReaderSize(r, sealedChunk+1), s: s, in: make([]byte, sealedChunk), out: make([]byte, 0, ChunkSize)}, nil
+}
+
+// Read decrypts the stream.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next reads and decrypts a chunk. A chunk followed by the end of the stream
+// has to be the final one.
+func (r *Reader) next() error {
+	n, err := io.ReadFull(r.r, r.in)
+	if err == io.EOF {
+		return ErrTruncated
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	_, peek := r.r.Peek(1)
+	final := peek == io.EOF
+	out, ok := secretbox.Open(r.out[:0], r.in[:n], r.s.nextNonce(final), &r.s.key)
+	if !ok {
+		if final {
+			// A stream cut at a chunk boundary ends with a non final chunk.
+			r.s.counter--
+			if _, ok = secretbox.Open(r.out[:0], r.in[:n], r.s.nextNonce(false), &r.s.key); ok {
+				return ErrTruncated
+			}
+		}
+		return errors.New("encrypted stream is corrupt")
+	}
+	r.buf, r.done = out, final
+	return nil
+}
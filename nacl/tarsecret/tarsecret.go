@@ -0,0 +1,196 @@
+/*
+Package tarsecret streams directory trees into encrypted tar archives and
+extracts them.
+
+Unlike piping tar through a saltsecret Writer, which buffers the whole
+archive, tarsecret encrypts the tar stream in chunks of 64KiB as it is
+written. A single key is derived from the user key and a random salt with
+scrypt, like saltsecret does, and every chunk is sealed with NaCl's
+secretbox. Chunk nonces hold a counter and mark the final chunk, so chunks
+that were reordered, removed or cut off are detected.
+
+File names, sizes, modes and modification times are all inside the
+ciphertext. Only the total size of the archive is visible.
+
+Extract verifies the whole archive, even when only some paths are
+extracted. Files are written as they are decrypted; when Extract returns an
+error the files extracted so far are authentic, but the archive is not
+complete.
+*/
+package tarsecret
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andmarios/crypto/nacl/internal/extract"
+)
+
+// Archive writes the directory tree at dir to w as an encrypted tar archive.
+// Regular files, directories and symbolic links are archived, with their
+// modes and modification times, by their path relative to dir.
+func Archive(w io.Writer, dir string, key []byte) error {
+	sw, err := NewWriter(w, key)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(sw)
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		switch {
+		case fi.Mode().IsRegular(), fi.IsDir():
+		case fi.Mode()&fs.ModeSymlink != 0:
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		// Owners are not restored; do not leak them.
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		hdr.Format = tar.FormatPAX
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		f.Close()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return sw.Close()
+}
+
+// List returns the headers of the files in an encrypted archive, verifying
+// the whole archive.
+func List(r io.Reader, key []byte) ([]*tar.Header, error) {
+	var headers []*tar.Header
+	err := walk(r, key, func(hdr *tar.Header, tr *tar.Reader) error {
+		headers = append(headers, hdr)
+		return nil
+	})
+	return headers, err
+}
+
+// Extract extracts an encrypted archive into dir. If paths are given, only
+// these paths, and everything under them if they are directories, are
+// extracted. The whole archive is verified in any case.
+func Extract(r io.Reader, dir string, key []byte, paths ...string) error {
+	var dirs []extract.Dir
+	found := make([]bool, len(paths))
+	err := walk(r, key, func(hdr *tar.Header, tr *tar.Reader) error {
+		name := strings.TrimSuffix(hdr.Name, "/")
+		if !extract.Selected(name, paths, found) {
+			return nil
+		}
+		target, err := extract.SafePath(dir, name, "archive")
+		if err != nil {
+			return err
+		}
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err = extract.Mkdir(target); err != nil {
+				return err
+			}
+			dirs = append(dirs, extract.Dir{Path: target, Mode: mode, ModTime: hdr.ModTime})
+			return nil
+		case tar.TypeReg:
+			f, err := extract.Create(target)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+			if err = os.Chmod(target, mode.Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err = os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			os.Remove(target)
+			return os.Symlink(hdr.Linkname, target)
+		default:
+			return nil
+		}
+		return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+	})
+	if err != nil {
+		return err
+	}
+	if err = extract.SetDirs(dirs); err != nil {
+		return err
+	}
+	for i, ok := range found {
+		if !ok {
+			return errors.New("path " + paths[i] + " not found in archive")
+		}
+	}
+	return nil
+}
+
+// walk calls f for every file of an encrypted archive and reads the archive
+// to its end.
+func walk(r io.Reader, key []byte, f func(hdr *tar.Header, tr *tar.Reader) error) error {
+	sr, err := NewReader(r, key)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(sr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err = f(hdr, tr); err != nil {
+			return err
+		}
+	}
+	// Read the padding after the tar trailer to verify the final chunk.
+	_, err = io.Copy(io.Discard, sr)
+	return err
+}
@@ -0,0 +1,181 @@
+package tarsecret
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andmarios/crypto/nacl/internal/testtree"
+)
+
+var key = []byte("qwerty")
+
+// newTree creates a directory tree with a file larger than a few chunks.
+func newTree(t *testing.T) (string, []byte) {
+	big := make([]byte, 3*ChunkSize+123)
+	rand.Read(big)
+	dir := testtree.New(t, map[string][]byte{
+		"secret-name.txt":        []byte("hello"),
+		"docs/report.pdf":        big,
+		"docs/nested/empty.conf": nil,
+	}, "secret-name.txt")
+	return dir, big
+}
+
+func TestArchive(t *testing.T) {
+	src, big := newTree(t)
+	var b bytes.Buffer
+	if err := Archive(&b, src, key); err != nil {
+		t.Fatal(err)
+	}
+	archive := b.Bytes()
+	if bytes.Contains(archive, []byte("secret-name")) || bytes.Contains(archive, []byte("report")) {
+		t.Errorf("Archive contains file names in plaintext.")
+	}
+
+	dst := t.TempDir()
+	if err := Extract(bytes.NewReader(archive), dst, key); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dst, "docs/report.pdf")); err != nil || !bytes.Equal(data, big) {
+		t.Errorf("Extract() did not restore the large file: %v", err)
+	}
+	fi, err := os.Stat(filepath.Join(dst, "secret-name.txt"))
+	if err != nil || fi.Mode().Perm() != 0600 || !fi.ModTime().Equal(testtree.ModTime) {
+		t.Errorf("Extract() did not restore the mode and time: %v, %v, %v", fi.Mode(), fi.ModTime(), err)
+	}
+	if fi, err = os.Stat(filepath.Join(dst, "docs")); err != nil || !fi.ModTime().Equal(testtree.ModTime) {
+		t.Errorf("Extract() did not restore the time of a directory: %v", err)
+	}
+	if link, err := os.Readlink(filepath.Join(dst, "link")); err != nil || link != "secret-name.txt" {
+		t.Errorf("Extract() did not restore the symbolic link: '%s', %v", link, err)
+	}
+	if _, err = os.Stat(filepath.Join(dst, "docs/nested/empty.conf")); err != nil {
+		t.Errorf("Extract() did not restore the empty file: %v", err)
+	}
+
+	headers, err := List(bytes.NewReader(archive), key)
+	if err != nil || len(headers) != 6 {
+		t.Errorf("List() returned %d headers, %v.", len(headers), err)
+	}
+
+	one := t.TempDir()
+	if err = Extract(bytes.NewReader(archive), one, key, "docs/nested"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(one, "docs/nested/empty.conf")); err != nil {
+		t.Errorf("Extract() of a path did not extract it: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(one, "secret-name.txt")); err == nil {
+		t.Errorf("Extract() of a path extracted other files.")
+	}
+	if err = Extract(bytes.NewReader(archive), t.TempDir(), key, "missing"); err == nil {
+		t.Errorf("Extract() of a missing path succeeds.")
+	}
+	if err = Extract(bytes.NewReader(archive), t.TempDir(), []byte("other")); err == nil {
+		t.Errorf("Extract() succeeds with the wrong key.")
+	}
+}
+
+func TestIntegrity(t *testing.T) {
+	src, _ := newTree(t)
+	var b bytes.Buffer
+	if err := Archive(&b, src, key); err != nil {
+		t.Fatal(err)
+	}
+	archive := b.Bytes()
+	chunk := headerSize + sealedChunk
+
+	truncated := archive[:headerSize+2*sealedChunk]
+	if err := Extract(bytes.NewReader(truncated), t.TempDir(), key); err != ErrTruncated {
+		t.Errorf("Extract() of a truncated archive returned %v.", err)
+	}
+	if _, err := List(bytes.NewReader(archive[:len(archive)-1]), key); err == nil {
+		t.Errorf("List() of an archive missing a byte succeeds.")
+	}
+
+	swapped := append([]byte{}, archive...)
+	copy(swapped[headerSize:chunk], archive[chunk:chunk+sealedChunk])
+	copy(swapped[chunk:chunk+sealedChunk], archive[headerSize:chunk])
+	if _, err := List(bytes.NewReader(swapped), key); err == nil {
+		t.Errorf("List() of an archive with swapped chunks succeeds.")
+	}
+
+	// A damaged final chunk is detected, even when only an earlier path is
+	// extracted.
+	damaged := append([]byte{}, archive...)
+	damaged[len(damaged)-5] ^= 1
+	if err := Extract(bytes.NewReader(damaged), t.TempDir(), key, "docs/nested"); err == nil {
+		t.Errorf("Extract() of a path does not verify the whole archive.")
+	}
+}
+
+func TestStream(t *testing.T) {
+	for _, size := range []int{0, 1, ChunkSize, 2 * ChunkSize, 2*ChunkSize + 1} {
+		data := make([]byte, size)
+		rand.Read(data)
+		var b bytes.Buffer
+		w, err := NewWriter(&b, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data[:size/3])
+		w.Write(data[size/3:])
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte("x")); err == nil {
+			t.Errorf("Write() after Close() succeeds.")
+		}
+		r, err := NewReader(&b, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(out, data) {
+			t.Errorf("Stream of %d bytes returned %d bytes, %v.", size, len(out), err)
+		}
+	}
+}
+
+func TestSymlinkOverwrite(t *testing.T) {
+	outside := t.TempDir()
+	victim := filepath.Join(outside, "victim")
+	ioutil.WriteFile(victim, []byte("safe"), 0600)
+	before, _ := os.Stat(outside)
+
+	// A symbolic link followed by a regular file and a directory of the same
+	// names must not write through the links.
+	var b bytes.Buffer
+	sw, err := NewWriter(&b, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(sw)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "evil", Linkname: victim, Mode: 0777})
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "evil", Size: 5, Mode: 0644})
+	tw.Write([]byte("pwned"))
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "dir", Linkname: outside, Mode: 0777})
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0777})
+	tw.Close()
+	sw.Close()
+
+	dir := t.TempDir()
+	if err = Extract(&b, dir, key); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := ioutil.ReadFile(victim); string(v) != "safe" {
+		t.Errorf("Extract() wrote %q through a symbolic link.", v)
+	}
+	if fi, err := os.Lstat(filepath.Join(dir, "evil")); err != nil || !fi.Mode().IsRegular() {
+		t.Errorf("evil is not a regular file: %v", err)
+	}
+	if fi, _ := os.Stat(outside); fi.Mode() != before.Mode() {
+		t.Errorf("Extract() changed the mode of a directory through a symbolic link to %v.", fi.Mode())
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Package ttlsecret implements time-limited tokens, in the spirit of Fernet,
+on top of padsecret or saltsecret.
+
+Every token carries its issue time, and optionally its expiry time, inside
+the encrypted (and thus authenticated) payload. DecryptWithTTL refuses tokens
+older than a TTL chosen by the receiver, while Decrypt and DecryptWithTTL
+both refuse tokens past the expiry time set by EncryptWithTTL. Tokens issued
+in the future are refused too, unless they are within the allowed clock skew.
+
+Expired tokens fail with ErrExpired, so callers can tell them apart from
+invalid ones.
+*/
+package ttlsecret
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/andmarios/crypto/nacl/adsecret"
+)
+
+// Errors returned when decrypting.
+var (
+	ErrExpired = errors.New("token has expired")
+	ErrFuture  = errors.New("token was issued in the future")
+)
+
+const (
+	version    byte = 0x80
+	headerSize      = 17 // version (1), issue time (8), expiry time (8)
+)
+
+// A TTLSecret holds the instance's cipher and time settings.
+// Now is the clock used to issue and check tokens; it defaults to time.Now and
+// may be replaced, for example in tests. Skew is the clock difference tolerated
+// between the issuer and the receiver of a token.
+type TTLSecret struct {
+	c    adsecret.Cipher
+	Now  func() time.Time
+	Skew time.Duration
+}
+
+// New creates a new TTLSecret instance that encrypts with c, usually a
+// padsecret.PadSecret or saltsecret.SaltSecret. The allowed clock skew is
+// zero; set Skew to tolerate unsynchronised clocks.
+func New(c adsecret.Cipher) *TTLSecret {
+	return &TTLSecret{c, time.Now, 0}
+}
+
+// Encrypt encrypts msg and stamps it with the current time. The token does
+// not expire on its own; use DecryptWithTTL to limit its age.
+func (c TTLSecret) Encrypt(msg []byte) ([]byte, error) {
+	return c.seal(msg, 0)
+}
+
+// EncryptWithTTL encrypts msg and stamps it with the current time and an
+// expiry time ttl later. A ttl of zero or less means no expiry time.
+func (c TTLSecret) EncryptWithTTL(msg []byte, ttl time.Duration) ([]byte, error) {
+	return c.seal(msg, ttl)
+}
+
+func (c TTLSecret) seal(msg []byte, ttl time.Duration) ([]byte, error) {
+	now := c.Now()
+	var expires int64
+	if ttl > 0 {
+		expires = now.Add(ttl).Unix()
+	}
+	in := make([]byte, headerSize, headerSize+len(msg))
+	in[0] = version
+	binary.BigEndian.PutUint64(in[1:], uint64(now.Unix()))
+	binary.BigEndian.PutUint64(in[9:], uint64(expires))
+	in = append(in, msg...)
+	return c.c.Encrypt(in)
+}
+
+// Decrypt decrypts a token and returns the message. It fails with ErrExpired
+// if the token has an expiry time that has passed.
+func (c TTLSecret) Decrypt(msg []byte) ([]byte, error) {
+	return c.DecryptWithTTL(msg, 0)
+}
+
+// DecryptWithTTL decrypts a token and returns the message. It fails with
+// ErrExpired if the token was issued more than ttl ago, or if it has an expiry
+// time that has passed. A ttl of zero or less only checks the expiry time.
+func (c TTLSecret) DecryptWithTTL(msg []byte, ttl time.Duration) ([]byte, error) {
+	out, issued, expires, err := c.open(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.Now()
+	if issued.After(now.Add(c.Skew)) {
+		return nil, ErrFuture
+	}
+	if ttl > 0 && !now.Add(-c.Skew).Before(issued.Add(ttl)) {
+		return nil, ErrExpired
+	}
+	if !expires.IsZero() && !now.Add(-c.Skew).Before(expires) {
+		return nil, ErrExpired
+	}
+	return out, nil
+}
+
+// IssuedAt decrypts a token and returns its issue time without checking it.
+func (c TTLSecret) IssuedAt(msg []byte) (time.Time, error) {
+	_, issued, _, err := c.open(msg)
+	return issued, err
+}
+
+// open decrypts a token and returns the message, the issue time and the
+// expiry time (zero if the token does not expire).
+func (c TTLSecret) open(msg []byte) (out []byte, issued, expires time.Time, err error) {
+	in, err := c.c.Decrypt(msg)
+	if err != nil {
+		return nil, issued, expires, err
+	}
+	if len(in) < headerSize || in[0] != version {
+		return nil, issued, expires, errors.New("not a ttlsecret token")
+	}
+	issued = time.Unix(int64(binary.BigEndian.Uint64(in[1:])), 0)
+	if e := int64(binary.BigEndian.Uint64(in[9:])); e != 0 {
+		expires = time.Unix(e, 0)
+	}
+	return in[headerSize:], issued, expires, nil
+}
@@ -0,0 +1,135 @@
+package ttlsecret
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/andmarios/crypto/nacl/padsecret"
+	"github.com/andmarios/crypto/nacl/saltsecret"
+)
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func newTTLSecret(t *testing.T) (*TTLSecret, *clock) {
+	p, err := padsecret.New("qwerty", "qwertyuiopasdfghjklzxcvbnm123456", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := &clock{time.Unix(1500000000, 0)}
+	c := New(p)
+	c.Now = clk.now
+	return c, clk
+}
+
+func TestDecryptWithTTL(t *testing.T) {
+	c, clk := newTTLSecret(t)
+	msg := []byte("hello world")
+
+	enc, err := c.Encrypt(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk.t = clk.t.Add(59 * time.Second)
+	dec, err := c.DecryptWithTTL(enc, time.Minute)
+	if err != nil {
+		t.Errorf("DecryptWithTTL() of fresh token failed: %v", err)
+	}
+	if !bytes.Equal(dec, msg) {
+		t.Errorf("Decoded message '%s' differs from encoded message '%s'.", dec, msg)
+	}
+
+	clk.t = clk.t.Add(time.Second)
+	if _, err = c.DecryptWithTTL(enc, time.Minute); err != ErrExpired {
+		t.Errorf("DecryptWithTTL() of old token returned %v, expected ErrExpired.", err)
+	}
+	if _, err = c.Decrypt(enc); err != nil {
+		t.Errorf("Decrypt() of token without expiry failed: %v", err)
+	}
+
+	issued, err := c.IssuedAt(enc)
+	if err != nil || !issued.Equal(time.Unix(1500000000, 0)) {
+		t.Errorf("IssuedAt() returned %v, %v.", issued, err)
+	}
+}
+
+func TestEncryptWithTTL(t *testing.T) {
+	c, clk := newTTLSecret(t)
+
+	enc, err := c.EncryptWithTTL([]byte("hello"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk.t = clk.t.Add(30 * time.Minute)
+	if _, err = c.Decrypt(enc); err != nil {
+		t.Errorf("Decrypt() of fresh token failed: %v", err)
+	}
+	clk.t = clk.t.Add(30 * time.Minute)
+	if _, err = c.Decrypt(enc); err != ErrExpired {
+		t.Errorf("Decrypt() of expired token returned %v, expected ErrExpired.", err)
+	}
+	if _, err = c.DecryptWithTTL(enc, 24*time.Hour); err != ErrExpired {
+		t.Errorf("DecryptWithTTL() ignores the token's expiry time.")
+	}
+}
+
+func TestSkew(t *testing.T) {
+	c, clk := newTTLSecret(t)
+	enc, _ := c.EncryptWithTTL([]byte("hello"), time.Minute)
+
+	// The receiver's clock is behind the issuer's.
+	clk.t = clk.t.Add(-10 * time.Second)
+	if _, err := c.Decrypt(enc); err != ErrFuture {
+		t.Errorf("Decrypt() of token from the future returned %v, expected ErrFuture.", err)
+	}
+	c.Skew = 30 * time.Second
+	if _, err := c.Decrypt(enc); err != nil {
+		t.Errorf("Decrypt() within allowed skew failed: %v", err)
+	}
+
+	// The receiver's clock is ahead of the issuer's.
+	clk.t = clk.t.Add(80 * time.Second)
+	if _, err := c.Decrypt(enc); err != nil {
+		t.Errorf("Decrypt() within allowed skew failed: %v", err)
+	}
+	clk.t = clk.t.Add(30 * time.Second)
+	if _, err := c.Decrypt(enc); err != ErrExpired {
+		t.Errorf("Decrypt() past allowed skew returned %v, expected ErrExpired.", err)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	c, _ := newTTLSecret(t)
+	enc, _ := c.Encrypt([]byte("hello"))
+	enc[len(enc)-1] ^= 0x01
+	if _, err := c.Decrypt(enc); err == nil || err == ErrExpired {
+		t.Errorf("Decrypt() of tampered token returned %v.", err)
+	}
+
+	p, _ := padsecret.New("qwerty", "qwertyuiopasdfghjklzxcvbnm123456", false)
+	plain, _ := p.Encrypt([]byte("hello"))
+	if _, err := c.Decrypt(plain); err == nil {
+		t.Errorf("Decrypt() accepts a message without timestamps.")
+	}
+}
+
+func TestSaltSecret(t *testing.T) {
+	s := saltsecret.New([]byte("qwerty"), false)
+	s.NPow = 10
+	c := New(s)
+	enc, err := c.EncryptWithTTL([]byte("hello"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := c.DecryptWithTTL(enc, time.Hour)
+	if err != nil || string(dec) != "hello" {
+		t.Errorf("DecryptWithTTL() with saltsecret failed: %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+/*
+Package paseto implements PASETO v4.local tokens.
+
+v4.local tokens are encrypted with XChaCha20 and authenticated with a
+BLAKE2b MAC over the header, the nonce, the ciphertext, the footer and an
+optional implicit assertion. The footer is authenticated but not encrypted;
+it is usually used for a key identifier. The implicit assertion is
+authenticated but not stored in the token at all; the receiver has to
+provide it.
+
+Keys are 32 bytes. New builds them from a key and a pad exactly like
+padsecret.New does, so the same secrets can be used for both.
+
+See https://github.com/paseto-standard/paseto-spec for the specification.
+*/
+package paseto
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/andmarios/crypto/nacl/padsecret"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+// Header is the prefix of every v4.local token.
+const Header = "v4.local."
+
+const (
+	keySize   = 32
+	nonceSize = 32
+	macSize   = 32
+)
+
+// ErrInvalid is returned for tokens that are malformed, were created with
+// another key, footer or implicit assertion, or were tampered with.
+var ErrInvalid = errors.New("invalid paseto token")
+
+var b64 = base64.RawURLEncoding.Strict()
+
+// A V4Local holds the key used to create and verify v4.local tokens.
+type V4Local struct {
+	key *[keySize]byte
+}
+
+// New creates a new V4Local instance. key and pad are combined into a 32
+// bytes key exactly like padsecret.New does; pad should be at least 32 bytes.
+func New(key, pad string) (*V4Local, error) {
+	k, err := padsecret.Key(key, pad)
+	if err != nil {
+		return nil, err
+	}
+	return &V4Local{k}, nil
+}
+
+// NewFromKey creates a new V4Local instance from a raw 32 bytes key.
+func NewFromKey(key *[32]byte) *V4Local {
+	k := new([keySize]byte)
+	copy(k[:], key[:])
+	return &V4Local{k}
+}
+
+// Encrypt creates a v4.local token from payload. footer is stored in the token
+// unencrypted; implicit is bound to the token but not stored in it. Both may be nil.
+func (c V4Local) Encrypt(payload, footer, implicit []byte) (string, error) {
+	n := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, n); err != nil {
+		return "", err
+	}
+	return c.encrypt(payload, footer, implicit, n)
+}
+
+func (c V4Local) encrypt(payload, footer, implicit, n []byte) (string, error) {
+	ek, n2, ak := c.splitKey(n)
+
+	ct := make([]byte, len(payload))
+	s, err := chacha20.NewUnauthenticatedCipher(ek, n2)
+	if err != nil {
+		return "", err
+	}
+	s.XORKeyStream(ct, payload)
+
+	t, err := mac(ak, pae([]byte(Header), n, ct, footer, implicit))
+	if err != nil {
+		return "", err
+	}
+
+	body := make([]byte, 0, len(n)+len(ct)+len(t))
+	body = append(body, n...)
+	body = append(body, ct...)
+	body = append(body, t...)
+	token := Header + b64.EncodeToString(body)
+	if len(footer) > 0 {
+		token += "." + b64.EncodeToString(footer)
+	}
+	return token, nil
+}
+
+// Decrypt verifies a v4.local token against implicit and returns its payload
+// and footer. The footer is authenticated too; callers that expect a specific
+// footer should compare it.
+func (c V4Local) Decrypt(token string, implicit []byte) (payload, footer []byte, err error) {
+	body, footer, err := split(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(body) < nonceSize+macSize {
+		return nil, nil, ErrInvalid
+	}
+	n := body[:nonceSize]
+	ct := body[nonceSize : len(body)-macSize]
+	t := body[len(body)-macSize:]
+
+	ek, n2, ak := c.splitKey(n)
+	t2, err := mac(ak, pae([]byte(Header), n, ct, footer, implicit))
+	if err != nil {
+		return nil, nil, err
+	}
+	if subtle.ConstantTimeCompare(t, t2) != 1 {
+		return nil, nil, ErrInvalid
+	}
+
+	payload = make([]byte, len(ct))
+	s, err := chacha20.NewUnauthenticatedCipher(ek, n2)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.XORKeyStream(payload, ct)
+	return payload, footer, nil
+}
+
+// Footer returns the (unverified) footer of a token. It is useful to pick a
+// key by its identifier before calling Decrypt.
+func Footer(token string) ([]byte, error) {
+	_, footer, err := split(token)
+	return footer, err
+}
+
+// split checks the header of a token and returns its decoded body and footer.
+func split(token string) (body, footer []byte, err error) {
+	if !strings.HasPrefix(token, Header) {
+		return nil, nil, ErrInvalid
+	}
+	parts := strings.Split(token[len(Header):], ".")
+	if len(parts) > 2 || (len(parts) == 2 && parts[1] == "") {
+		return nil, nil, ErrInvalid
+	}
+	body, err = b64.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, ErrInvalid
+	}
+	if len(parts) == 2 {
+		footer, err = b64.DecodeString(parts[1])
+		if err != nil {
+			return nil, nil, ErrInvalid
+		}
+	}
+	return body, footer, nil
+}
+
+// splitKey derives the encryption key, the XChaCha20 nonce and the
+// authentication key for the nonce n.
+func (c V4Local) splitKey(n []byte) (ek, n2, ak []byte) {
+	h, _ := blake2b.New(56, c.key[:])
+	h.Write([]byte("paseto-encryption-key"))
+	h.Write(n)
+	tmp := h.Sum(nil)
+
+	h, _ = blake2b.New(32, c.key[:])
+	h.Write([]byte("paseto-auth-key-for-aead"))
+	h.Write(n)
+	return tmp[:32], tmp[32:], h.Sum(nil)
+}
+
+func mac(key, msg []byte) ([]byte, error) {
+	h, err := blake2b.New(macSize, key)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(msg)
+	return h.Sum(nil), nil
+}
+
+// pae implements PASETO's Pre-Authentication Encoding.
+func pae(pieces ...[]byte) []byte {
+	out := le64(nil, len(pieces))
+	for _, p := range pieces {
+		out = le64(out, len(p))
+		out = append(out, p...)
+	}
+	return out
+}
+
+func le64(b []byte, n int) []byte {
+	return binary.LittleEndian.AppendUint64(b, uint64(n)&^(1<<63))
+}
@@ -0,0 +1,111 @@
+package paseto
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+type vector struct {
+	Name       string  `json:"name"`
+	ExpectFail bool    `json:"expect-fail"`
+	Key        string  `json:"key"`
+	Nonce      string  `json:"nonce"`
+	Token      string  `json:"token"`
+	Payload    *string `json:"payload"`
+	Footer     string  `json:"footer"`
+	Implicit   string  `json:"implicit-assertion"`
+}
+
+// TestVectors runs the official v4 test vectors (testdata/v4.json, from
+// github.com/paseto-standard/test-vectors). Public key vectors are skipped.
+func TestVectors(t *testing.T) {
+	data, err := os.ReadFile("testdata/v4.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors struct {
+		Tests []vector `json:"tests"`
+	}
+	if err = json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range vectors.Tests {
+		if v.Key == "" {
+			continue
+		}
+		key, _ := hex.DecodeString(v.Key)
+		var k [32]byte
+		copy(k[:], key)
+		c := NewFromKey(&k)
+
+		payload, footer, err := c.Decrypt(v.Token, []byte(v.Implicit))
+		if v.ExpectFail {
+			if err == nil {
+				t.Errorf("%s: Decrypt() accepts invalid token.", v.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Decrypt() failed: %v", v.Name, err)
+			continue
+		}
+		if string(payload) != *v.Payload || string(footer) != v.Footer {
+			t.Errorf("%s: Decrypt() returned payload '%s', footer '%s'.", v.Name, payload, footer)
+		}
+
+		nonce, _ := hex.DecodeString(v.Nonce)
+		token, err := c.encrypt([]byte(*v.Payload), []byte(v.Footer), []byte(v.Implicit), nonce)
+		if err != nil {
+			t.Errorf("%s: encrypt() failed: %v", v.Name, err)
+		}
+		if token != v.Token {
+			t.Errorf("%s: encrypt() returned\n%s\nexpected\n%s", v.Name, token, v.Token)
+		}
+	}
+}
+
+func TestPackage(t *testing.T) {
+	if _, err := New("qwerty", "short pad"); err == nil {
+		t.Errorf("New() accepts pad smaller than 32 bytes")
+	}
+	c, err := New("qwerty", "qwertyuiopasdfghjklzxcvbnm123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := c.Encrypt([]byte(`{"sub":"42"}`), []byte(`{"kid":"k1"}`), []byte("api"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, Header) {
+		t.Errorf("Token '%s' lacks the v4.local header.", token)
+	}
+
+	footer, err := Footer(token)
+	if err != nil || string(footer) != `{"kid":"k1"}` {
+		t.Errorf("Footer() returned '%s', %v.", footer, err)
+	}
+
+	payload, _, err := c.Decrypt(token, []byte("api"))
+	if err != nil || string(payload) != `{"sub":"42"}` {
+		t.Errorf("Decrypt() returned '%s', %v.", payload, err)
+	}
+
+	if _, _, err = c.Decrypt(token, []byte("web")); err != ErrInvalid {
+		t.Errorf("Decrypt() with wrong implicit assertion returned %v.", err)
+	}
+
+	other, _ := New("azerty", "qwertyuiopasdfghjklzxcvbnm123456")
+	if _, _, err = other.Decrypt(token, []byte("api")); err != ErrInvalid {
+		t.Errorf("Decrypt() with wrong key returned %v.", err)
+	}
+
+	tampered := token[:strings.LastIndex(token, ".")+1] + b64.EncodeToString([]byte(`{"kid":"k2"}`))
+	if _, _, err = c.Decrypt(tampered, []byte("api")); err != ErrInvalid {
+		t.Errorf("Decrypt() with replaced footer returned %v.", err)
+	}
+}
@@ -0,0 +1,513 @@
+/*
+Package zipaes reads and writes WinZip AES-256 encrypted zip entries with
+archive/zip, so that encrypted archives can be exchanged with 7-Zip, WinZip
+and other tools.
+
+The format is described at https://www.winzip.com/en/support/aes-encryption/.
+Keys are derived from a password with PBKDF2-HMAC-SHA1 (1000 iterations) and
+a random salt per entry. The (optionally deflated) data is encrypted with
+AES-256 in WinZip's counter mode and authenticated with HMAC-SHA1, truncated
+to 10 bytes. A 2 bytes password verifier tells wrong passwords apart from
+corrupt data.
+
+Writer writes AE-2 entries, which store a zero CRC-32 so that the checksum of
+the plaintext does not leak. RegisterCompressor plugs the encryption into a
+plain zip.Writer instead; since archive/zip stores the CRC-32 of entries
+created that way, FileHeader marks them as AE-1. RegisterDecompressor lets a
+zip.Reader open both.
+
+As in the WinZip format, file names, sizes and times are not encrypted, and
+the authentication code is only checked once an entry is read to its end;
+Read returns ErrAuthentication there if the data was modified.
+*/
+package zipaes
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Method is the compression method of WinZip AES entries. The actual
+// compression method is stored in an extra field.
+const Method uint16 = 99
+
+// Vendor versions of WinZip AES entries.
+const (
+	AE1 = 1
+	AE2 = 2
+)
+
+const (
+	extraID    = 0x9901
+	extraSize  = 7
+	strength   = 3 // AES-256
+	keySize    = 32
+	saltSize   = 16
+	pvSize     = 2
+	macSize    = 10
+	iterations = 1000
+
+	flagEncrypted = 0x1
+	flagUTF8      = 0x800
+	zipVersion51  = 51
+	creatorUnix   = 3
+)
+
+// Errors returned when reading entries.
+var (
+	ErrPassword       = errors.New("zipaes: wrong password")
+	ErrAuthentication = errors.New("zipaes: authentication failed")
+)
+
+// keys derives the AES key, the HMAC key and the password verifier.
+func keys(password string, salt []byte) (aesKey, macKey, pv []byte) {
+	k := pbkdf2.Key([]byte(password), salt, iterations, 2*keySize+pvSize, sha1.New)
+	return k[:keySize], k[keySize : 2*keySize], k[2*keySize:]
+}
+
+// ctr is WinZip's AES counter mode: a little endian counter starting at 1.
+type ctr struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	pos     int
+}
+
+func newCTR(key []byte) (*ctr, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &ctr{block: block, pos: aes.BlockSize}, nil
+}
+
+func (c *ctr) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.pos == aes.BlockSize {
+			for j := range c.counter {
+				c.counter[j]++
+				if c.counter[j] != 0 {
+					break
+				}
+			}
+			c.block.Encrypt(c.stream[:], c.counter[:])
+			c.pos = 0
+		}
+		dst[i] = src[i] ^ c.stream[c.pos]
+		c.pos++
+	}
+}
+
+// Extra returns the extra field of a WinZip AES entry.
+func Extra(version, method uint16) []byte {
+	b := make([]byte, 4+extraSize)
+	binary.LittleEndian.PutUint16(b, extraID)
+	binary.LittleEndian.PutUint16(b[2:], extraSize)
+	binary.LittleEndian.PutUint16(b[4:], version)
+	b[6], b[7], b[8] = 'A', 'E', strength
+	binary.LittleEndian.PutUint16(b[9:], method)
+	return b
+}
+
+// parseExtra returns the actual compression method of an entry.
+func parseExtra(extra []byte) (method uint16, err error) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		if id == extraID {
+			b := extra[4 : 4+size]
+			if size != extraSize || b[2] != 'A' || b[3] != 'E' {
+				return 0, zip.ErrFormat
+			}
+			if b[4] != strength {
+				return 0, errors.New("zipaes: only AES-256 entries are supported")
+			}
+			return binary.LittleEndian.Uint16(b[5:]), nil
+		}
+		extra = extra[4+size:]
+	}
+	return 0, zip.ErrFormat
+}
+
+// An encrypter compresses, encrypts and authenticates the data of an entry.
+type encrypter struct {
+	w    io.Writer
+	ctr  *ctr
+	mac  hash.Hash
+	comp io.WriteCloser
+	head []byte
+	buf  []byte
+}
+
+func newEncrypter(w io.Writer, password string, method uint16) (*encrypter, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aesKey, macKey, pv := keys(password, salt)
+	c, err := newCTR(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	// archive/zip creates compressors before writing the local header, so
+	// the salt and password verifier are written with the first data.
+	e := &encrypter{w: w, ctr: c, mac: hmac.New(sha1.New, macKey), head: append(salt, pv...)}
+	switch method {
+	case zip.Store:
+	case zip.Deflate:
+		if e.comp, err = flate.NewWriter(cipherWriter{e}, flate.DefaultCompression); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, zip.ErrAlgorithm
+	}
+	return e, nil
+}
+
+func (e *encrypter) Write(p []byte) (int, error) {
+	if e.comp != nil {
+		return e.comp.Write(p)
+	}
+	return cipherWriter{e}.Write(p)
+}
+
+// Close flushes the compressor and writes the authentication code.
+func (e *encrypter) Close() error {
+	if e.comp != nil {
+		if err := e.comp.Close(); err != nil {
+			return err
+		}
+	}
+	if err := e.writeHead(); err != nil {
+		return err
+	}
+	_, err := e.w.Write(e.mac.Sum(nil)[:macSize])
+	return err
+}
+
+func (e *encrypter) writeHead() error {
+	if e.head == nil {
+		return nil
+	}
+	_, err := e.w.Write(e.head)
+	e.head = nil
+	return err
+}
+
+// cipherWriter encrypts the (compressed) data.
+type cipherWriter struct {
+	e *encrypter
+}
+
+func (c cipherWriter) Write(p []byte) (int, error) {
+	if err := c.e.writeHead(); err != nil {
+		return 0, err
+	}
+	if cap(c.e.buf) < len(p) {
+		c.e.buf = make([]byte, len(p))
+	}
+	buf := c.e.buf[:len(p)]
+	c.e.ctr.XORKeyStream(buf, p)
+	c.e.mac.Write(buf)
+	return c.e.w.Write(buf)
+}
+
+// newDecrypter returns a reader of the plaintext of the raw entry data r,
+// of size bytes.
+func newDecrypter(r io.Reader, size int64, password string, method uint16) (io.ReadCloser, error) {
+	if size < saltSize+pvSize+macSize {
+		return nil, zip.ErrFormat
+	}
+	head := make([]byte, saltSize+pvSize)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	aesKey, macKey, pv := keys(password, head[:saltSize])
+	if subtle.ConstantTimeCompare(pv, head[saltSize:]) != 1 {
+		return nil, ErrPassword
+	}
+	c, err := newCTR(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	d := &decrypter{
+		r:   io.LimitReader(r, size-saltSize-pvSize-macSize),
+		raw: r,
+		ctr: c,
+		mac: hmac.New(sha1.New, macKey),
+	}
+	switch method {
+	case zip.Store:
+		return io.NopCloser(d), nil
+	case zip.Deflate:
+		return &inflater{flate.NewReader(d), d}, nil
+	}
+	return nil, zip.ErrAlgorithm
+}
+
+// A decrypter decrypts the data of an entry and checks its authentication
+// code at the end.
+type decrypter struct {
+	r   io.Reader
+	raw io.Reader
+	ctr *ctr
+	mac hash.Hash
+	err error
+}
+
+func (d *decrypter) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	n, err := d.r.Read(p)
+	d.mac.Write(p[:n])
+	d.ctr.XORKeyStream(p[:n], p[:n])
+	if err == io.EOF {
+		code := make([]byte, macSize)
+		if _, err = io.ReadFull(d.raw, code); err != nil {
+			d.err = err
+		} else if !hmac.Equal(code, d.mac.Sum(nil)[:macSize]) {
+			d.err = ErrAuthentication
+		} else {
+			d.err = io.EOF
+		}
+		return n, d.err
+	}
+	return n, err
+}
+
+// An inflater decompresses the data of an entry and reads the ciphertext to
+// its end, so that the authentication code is checked.
+type inflater struct {
+	rc io.ReadCloser
+	d  *decrypter
+}
+
+func (i *inflater) Read(p []byte) (int, error) {
+	n, err := i.rc.Read(p)
+	if err == io.EOF {
+		if _, err = io.Copy(io.Discard, i.d); err == nil {
+			err = io.EOF
+		}
+	}
+	return n, err
+}
+
+func (i *inflater) Close() error {
+	return i.rc.Close()
+}
+
+// errReader returns err on every Read.
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+func (r errReader) Close() error             { return nil }
+
+// RegisterDecompressor lets r open the WinZip AES entries encrypted with
+// password, AE-1 and AE-2, with File.Open. Entries encrypted with another
+// password return ErrPassword when read.
+func RegisterDecompressor(r *zip.Reader, password string) {
+	// Decompressors only see the data of an entry; find the entry by the
+	// offset of its data.
+	methods := make(map[int64]uint16)
+	errs := make(map[int64]error)
+	for _, f := range r.File {
+		if f.Method != Method {
+			continue
+		}
+		off, err := f.DataOffset()
+		if err != nil {
+			continue
+		}
+		if methods[off], err = parseExtra(f.Extra); err != nil {
+			errs[off] = err
+		}
+	}
+	r.RegisterDecompressor(Method, func(r io.Reader) io.ReadCloser {
+		sr, ok := r.(*io.SectionReader)
+		if !ok {
+			return errReader{zip.ErrFormat}
+		}
+		_, off, size := sr.Outer()
+		method, ok := methods[off]
+		if !ok {
+			return errReader{zip.ErrFormat}
+		}
+		if errs[off] != nil {
+			return errReader{errs[off]}
+		}
+		rc, err := newDecrypter(sr, size, password, method)
+		if err != nil {
+			return errReader{err}
+		}
+		return rc
+	})
+}
+
+// RegisterCompressor lets w write WinZip AES entries encrypted with password.
+// Create them with w.CreateHeader(FileHeader(name)).
+func RegisterCompressor(w *zip.Writer, password string) {
+	w.RegisterCompressor(Method, func(out io.Writer) (io.WriteCloser, error) {
+		return newEncrypter(out, password, zip.Deflate)
+	})
+}
+
+// FileHeader returns the header of a deflated AE-1 entry, for zip.Writers set
+// up with RegisterCompressor.
+func FileHeader(name string) *zip.FileHeader {
+	return &zip.FileHeader{
+		Name:     name,
+		Method:   Method,
+		Flags:    flagEncrypted,
+		Extra:    Extra(AE1, zip.Deflate),
+		Modified: time.Now(),
+	}
+}
+
+// A Writer writes zip archives with AE-2 entries.
+//
+// archive/zip only reads entries with a data descriptor if it holds the
+// CRC-32 of their data, so the encrypted data of each file is kept in memory
+// until the file is complete and then written with its sizes.
+type Writer struct {
+	zw       *zip.Writer
+	password string
+	last     *entry
+}
+
+// An entry is the file being written.
+type entry struct {
+	fh   *zip.FileHeader
+	enc  *encrypter
+	buf  *bytes.Buffer
+	data *countWriter
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewWriter creates a Writer that writes a zip archive to w, encrypting every
+// file with password.
+func NewWriter(w io.Writer, password string) *Writer {
+	return &Writer{zw: zip.NewWriter(w), password: password}
+}
+
+// Create adds a deflated file to the archive. Like with zip.Writer, the file
+// has to be written before the next call to Create, CreateHeader or Close.
+func (w *Writer) Create(name string) (io.Writer, error) {
+	return w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+}
+
+// CreateHeader adds a file described by fh to the archive. fh.Method, Store
+// or Deflate, is the method used before encrypting. Directories (names ending
+// in "/") are not encrypted.
+func (w *Writer) CreateHeader(fh *zip.FileHeader) (io.Writer, error) {
+	if err := w.finish(); err != nil {
+		return nil, err
+	}
+	if len(fh.Name) > 0 && fh.Name[len(fh.Name)-1] == '/' {
+		return w.zw.CreateHeader(fh)
+	}
+	method := fh.Method
+	if method != zip.Store && method != zip.Deflate {
+		return nil, zip.ErrAlgorithm
+	}
+
+	// CreateRaw writes the header as given, so fill in what CreateHeader
+	// would have.
+	fh.Method = Method
+	fh.Flags |= flagEncrypted
+	if !isASCII(fh.Name) || !isASCII(fh.Comment) {
+		fh.Flags |= flagUTF8
+	}
+	fh.CreatorVersion = fh.CreatorVersion&0xff00 | zipVersion51
+	if fh.CreatorVersion>>8 == 0 {
+		fh.CreatorVersion |= creatorUnix << 8
+	}
+	fh.ReaderVersion = zipVersion51
+	if !fh.Modified.IsZero() {
+		fh.ModifiedDate, fh.ModifiedTime = msDosTime(fh.Modified)
+	}
+	fh.Extra = append(fh.Extra, Extra(AE2, method)...)
+	fh.CRC32, fh.CompressedSize64, fh.UncompressedSize64 = 0, 0, 0
+
+	e := &entry{fh: fh, buf: new(bytes.Buffer)}
+	var err error
+	if e.enc, err = newEncrypter(e.buf, w.password, method); err != nil {
+		return nil, err
+	}
+	e.data = &countWriter{w: e.enc}
+	w.last = e
+	return e.data, nil
+}
+
+// finish writes the last file to the archive.
+func (w *Writer) finish() error {
+	e := w.last
+	if e == nil {
+		return nil
+	}
+	w.last = nil
+	if err := e.enc.Close(); err != nil {
+		return err
+	}
+	e.fh.CompressedSize64 = uint64(e.buf.Len())
+	e.fh.UncompressedSize64 = uint64(e.data.n)
+	raw, err := w.zw.CreateRaw(e.fh)
+	if err != nil {
+		return err
+	}
+	_, err = e.buf.WriteTo(raw)
+	return err
+}
+
+// SetComment sets the comment of the archive.
+func (w *Writer) SetComment(comment string) error {
+	return w.zw.SetComment(comment)
+}
+
+// Close finishes the archive. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if err := w.finish(); err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
+
+func isASCII(s string) bool {
+	return bytes.IndexFunc([]byte(s), func(r rune) bool { return r >= 0x80 }) < 0
+}
+
+// msDosTime converts t to the MS-DOS date and time of zip headers.
+func msDosTime(t time.Time) (date, tm uint16) {
+	date = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	tm = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, tm
+}
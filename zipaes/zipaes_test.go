@@ -0,0 +1,197 @@
+package zipaes
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"testing"
+)
+
+const password = "golang"
+
+// readAll opens every file of the zip archive b with password.
+func readAll(t *testing.T, b []byte, password string) (map[string][]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	RegisterDecompressor(zr, password)
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		files[f.Name] = data
+	}
+	return files, nil
+}
+
+func TestReadWinZip(t *testing.T) {
+	// Archives written by WinZip, from github.com/alexmullins/zip.
+	tests := map[string]map[string]string{
+		"testdata/hello-aes.zip": {"hello.txt": "Hello World\r\n"},
+		"testdata/world-aes.zip": {"hello.txt": "hello", "world.txt": "world"},
+	}
+	for name, want := range tests {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files, err := readAll(t, b, password)
+		if err != nil {
+			t.Fatalf("Reading %s: %s", name, err)
+		}
+		for f, data := range want {
+			if string(files[f]) != data {
+				t.Errorf("%s: %s is %q, expected %q.", name, f, files[f], data)
+			}
+		}
+		if _, err = readAll(t, b, "wrong"); err != ErrPassword {
+			t.Errorf("%s with wrong password: expected ErrPassword, got %v.", name, err)
+		}
+	}
+}
+
+func testFiles() map[string][]byte {
+	big := make([]byte, 100000)
+	rand.Read(big)
+	return map[string][]byte{
+		"notes.txt":     bytes.Repeat([]byte("compress me "), 1000),
+		"dir/random":    big,
+		"dir/empty.txt": {},
+	}
+}
+
+func TestWriter(t *testing.T) {
+	files := testFiles()
+	var b bytes.Buffer
+	w := NewWriter(&b, password)
+	for name, data := range files {
+		fh := &zip.FileHeader{Name: name, Method: zip.Deflate}
+		if name == "dir/random" {
+			fh.Method = zip.Store
+		}
+		f, err := w.CreateHeader(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(data)
+	}
+	if _, err := w.Create("dir/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b.Bytes(), []byte("compress me")) {
+		t.Errorf("Archive contains plaintext.")
+	}
+
+	zr, _ := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	for _, f := range zr.File {
+		if f.Name == "dir/" {
+			continue
+		}
+		if f.Method != Method || f.Flags&flagEncrypted == 0 || f.CRC32 != 0 {
+			t.Errorf("%s: method %d, flags %#x, CRC-32 %#x; expected an AE-2 entry.", f.Name, f.Method, f.Flags, f.CRC32)
+		}
+		if f.UncompressedSize64 != uint64(len(files[f.Name])) {
+			t.Errorf("%s: size %d, expected %d.", f.Name, f.UncompressedSize64, len(files[f.Name]))
+		}
+	}
+	for _, f := range zr.File {
+		if f.Name == "notes.txt" && f.CompressedSize64 > 1000 {
+			t.Errorf("notes.txt was not compressed.")
+		}
+	}
+
+	got, err := readAll(t, b.Bytes(), password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if !bytes.Equal(got[name], data) {
+			t.Errorf("%s does not round-trip.", name)
+		}
+	}
+	if _, err = readAll(t, b.Bytes(), "wrong"); err != ErrPassword {
+		t.Errorf("Expected ErrPassword, got %v.", err)
+	}
+}
+
+func TestRegisterCompressor(t *testing.T) {
+	files := testFiles()
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	RegisterCompressor(zw, password)
+	for name, data := range files {
+		f, err := zw.CreateHeader(FileHeader(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readAll(t, b.Bytes(), password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if !bytes.Equal(got[name], data) {
+			t.Errorf("%s does not round-trip.", name)
+		}
+	}
+}
+
+func TestTamper(t *testing.T) {
+	for _, method := range []uint16{zip.Store, zip.Deflate} {
+		var b bytes.Buffer
+		w := NewWriter(&b, password)
+		f, _ := w.CreateHeader(&zip.FileHeader{Name: "a.txt", Method: method})
+		f.Write(bytes.Repeat([]byte("attack at dawn "), 100))
+		w.Close()
+
+		// Flip a bit of the ciphertext, after the local header, salt and
+		// password verifier.
+		archive := b.Bytes()
+		zr, _ := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		off, _ := zr.File[0].DataOffset()
+		archive[off+saltSize+pvSize+5] ^= 1
+
+		_, err := readAll(t, archive, password)
+		if err == nil || err == ErrPassword {
+			t.Errorf("Method %d: expected an error for modified data, got %v.", method, err)
+		}
+		if method == zip.Store && err != ErrAuthentication {
+			t.Errorf("Method %d: expected ErrAuthentication, got %v.", method, err)
+		}
+	}
+}
+
+func TestCTR(t *testing.T) {
+	// Encrypting in pieces matches encrypting at once.
+	key := make([]byte, keySize)
+	data := make([]byte, 100)
+	rand.Read(data)
+	c1, _ := newCTR(key)
+	c2, _ := newCTR(key)
+	once := make([]byte, len(data))
+	c1.XORKeyStream(once, data)
+	pieces := make([]byte, len(data))
+	for i := 0; i < len(data); i += 7 {
+		end := min(i+7, len(data))
+		c2.XORKeyStream(pieces[i:end], data[i:end])
+	}
+	if !bytes.Equal(once, pieces) {
+		t.Errorf("Counter mode depends on write sizes.")
+	}
+}